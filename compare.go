@@ -35,3 +35,10 @@ func MinIn[T int | int8 | int16 | int32 | int64 |
 	// 返回
 	return n
 }
+
+// Ordered 是可以使用 < 比较大小的类型
+type Ordered interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+		~float32 | ~float64 | ~string
+}
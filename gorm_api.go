@@ -2,11 +2,10 @@ package util
 
 import (
 	"context"
-	"fmt"
 	"reflect"
-	"strings"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // GORMBaseModel 基本字段
@@ -35,12 +34,30 @@ type GORMListPage struct {
 	Offset *int `form:"offset" binding:"omitempty,min=0"`
 	// 条数，小于 1 不匹配
 	Count *int `form:"count" binding:"omitempty,min=1"`
-	// 排序，"column [desc]"
+	// 排序，Init 原样使用，"column [desc]" 。
+	// InitOrder 和 GORMList 只能使用白名单中的键，"name,-createdAt" ，- 是 desc
 	Order string `form:"order"`
 }
 
-// Init 初始化 db
+// Init 初始化 db ，Order 原样传给 db.Order ，不做检查，
+// 来自请求的 Order 使用 InitOrder
 func (m *GORMListPage) Init(db *gorm.DB) *gorm.DB {
+	return m.init(db, m.rawOrder())
+}
+
+// InitOrder 初始化 db ，columns 是排序的白名单，参考 GORMOrderQuery ，
+// Order 不合法使用 db.AddError 返回 GORMOrderError
+func (m *GORMListPage) InitOrder(db *gorm.DB, columns map[string]string) *gorm.DB {
+	orders, err := m.parseOrder(columns)
+	if err != nil {
+		db.AddError(err)
+		return db
+	}
+	return m.init(db, orders)
+}
+
+// init 初始化分页和排序
+func (m *GORMListPage) init(db *gorm.DB, orders []clause.OrderByColumn) *gorm.DB {
 	// 分页
 	if m.Offset != nil {
 		db = db.Offset(*m.Offset)
@@ -49,8 +66,8 @@ func (m *GORMListPage) Init(db *gorm.DB) *gorm.DB {
 		db = db.Limit(*m.Count)
 	}
 	// 排序
-	if m.Order != "" {
-		db = db.Order(m.Order)
+	for _, o := range orders {
+		db = db.Order(o)
 	}
 	//
 	return db
@@ -68,6 +85,9 @@ type GORMListData[M any] struct {
 type GORMDB[K, M any] struct {
 	D *gorm.DB
 	M M
+	// 乐观锁的版本字段名称，比如 "Version" 或者 "UpdatedAt" ，为空不使用。
+	// 不为空时，Update 和 Save 添加版本的条件，版本不一致返回 GORMConflictError
+	Version string
 }
 
 // NewGORMDB 返回新的 GORMDB
@@ -121,6 +141,10 @@ func (g *GORMDB[K, M]) Save(m M) (int64, error) {
 
 // SaveWithContext 保存
 func (g *GORMDB[K, M]) SaveWithContext(ctx context.Context, m M) (int64, error) {
+	if g.Version != "" {
+		db, _ := gormVersionUpdate(g.D.WithContext(ctx).Model(m), m, g.Version, true)
+		return db.RowsAffected, db.Error
+	}
 	db := g.ModelWithContext(ctx).Save(m)
 	return db.RowsAffected, db.Error
 }
@@ -143,29 +167,43 @@ func (g *GORMDB[K, M]) Update(m M) (int64, error) {
 
 // UpdateWithContext 更新
 func (g *GORMDB[K, M]) UpdateWithContext(ctx context.Context, m M) (int64, error) {
+	if g.Version != "" {
+		db, _ := gormVersionUpdate(g.D.WithContext(ctx).Model(m), m, g.Version, false)
+		return db.RowsAffected, db.Error
+	}
 	db := g.ModelWithContext(ctx).Updates(m)
 	return db.RowsAffected, db.Error
 }
 
-// Delete 删除
+// Delete 删除，K 是结构的时候是复合主键，参考 WhereStructKey
 func (g *GORMDB[K, M]) Delete(k K) (int64, error) {
 	return g.DeleteWithContext(context.Background(), k)
 }
 
-// DeleteWithContext 删除
+// DeleteWithContext 删除，K 是结构的时候是复合主键，参考 WhereStructKey
 func (g *GORMDB[K, M]) DeleteWithContext(ctx context.Context, k K) (int64, error) {
-	db := g.ModelWithContext(ctx).Delete(g.M, k)
+	var db *gorm.DB
+	if isGORMStructKey[K]() {
+		db = WhereStructKey(g.ModelWithContext(ctx), k).Delete(g.M)
+	} else {
+		db = g.ModelWithContext(ctx).Delete(g.M, k)
+	}
 	return db.RowsAffected, db.Error
 }
 
-// BatchDelete 批量删除
+// BatchDelete 批量删除，K 是结构的时候是复合主键，参考 WhereStructKeys
 func (g *GORMDB[K, M]) BatchDelete(ks []K) (int64, error) {
 	return g.BatchDeleteWithContext(context.Background(), ks)
 }
 
-// BatchDeleteWithContext 批量删除
+// BatchDeleteWithContext 批量删除，K 是结构的时候是复合主键，参考 WhereStructKeys
 func (g *GORMDB[K, M]) BatchDeleteWithContext(ctx context.Context, ks []K) (int64, error) {
-	db := g.ModelWithContext(ctx).Delete(g.M, ks)
+	var db *gorm.DB
+	if isGORMStructKey[K]() {
+		db = WhereStructKeys(g.ModelWithContext(ctx), ks).Delete(g.M)
+	} else {
+		db = g.ModelWithContext(ctx).Delete(g.M, ks)
+	}
 	return db.RowsAffected, db.Error
 }
 
@@ -207,15 +245,20 @@ func (g *GORMDB[K, M]) SelectWithContext(ctx context.Context, m M, c ...string)
 	return true, nil
 }
 
-// In 根据主键查询，where in ks
+// In 根据主键查询，where in ks ，K 是结构的时候是复合主键，参考 WhereStructKeys
 func (g *GORMDB[K, M]) In(ks []K) ([]M, error) {
 	return g.InWithContext(context.Background(), ks)
 }
 
-// InWithContext 根据主键查询，where in ks
+// InWithContext 根据主键查询，where in ks ，K 是结构的时候是复合主键，参考 WhereStructKeys
 func (g *GORMDB[K, M]) InWithContext(ctx context.Context, ks []K) ([]M, error) {
 	var ms []M
-	err := g.ModelWithContext(ctx).Find(&ms, ks).Error
+	var err error
+	if isGORMStructKey[K]() {
+		err = WhereStructKeys(g.ModelWithContext(ctx), ks).Find(&ms).Error
+	} else {
+		err = g.ModelWithContext(ctx).Find(&ms, ks).Error
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -227,19 +270,35 @@ var (
 	GORMInitQueryTag = "gq"
 )
 
-// GORMInitQuery 将 q 格式化到 where ，全部是 AND ，略过空值
+// GORMInitQuery 将 q 格式化到 where ，全部是 AND ，略过空值，
+// 空值是空指针，空字符串和空切片。
+// tag 是 "操作" 或者 "操作=列名" ，没有列名使用字段名，"-" 或者没有 tag 略过，
+// 不认识的操作使用 db.AddError 返回错误。
+// 列名只能是字母，数字和下划线，可以带上表名，比如 `gq:"eq=User.Name"` ，不合法的使用 db.AddError 返回错误，
+// 列使用 clause.Column ，由 gorm 按照数据库的方言加引号，下面的例子省略了引号。
+// 多个列使用 | 分隔，是 OR ，比如 `gq:"like=Name|Phone"` 是 (Name LIKE ? OR Phone LIKE ?) 。
+// 结构字段是一组条件，没有 tag 或者 `gq:"and"` 是 AND ，`gq:"or"` 是 OR ，可以嵌套，
+// 比如 `gq:"or"` 的结构里面有两个结构字段，就是 (... AND ...) OR (... AND ...) 。
+// 结构字段有其他的 tag 作为值，比如 time.Time
 //
 //	type query struct {
-//	  A *int64 `gq:"eq"` db.Where("`A` = ?", A)
-//	  B string `gq:"like"` db.Where("`B` LiKE %%s%%", B)
-//	  C *int64 `gq:"gt=A"` db.Where("`A` < ?", C)
-//	  D *int64 `gq:"gte=A"` db.Where("`A` <= ?", D)
-//	  E *int64 `gq:"lt=A"` db.Where("`A` > ?", E)
-//	  F *int64 `gq:"let=A"` db.Where("`A` >= ?", F)
-//	  G *int64 `gq:"neq"` db.Where("`G` != ?", G)
+//	  A *int64 `gq:"eq"` db.Where("A = ?", A)
+//	  B string `gq:"like"` db.Where("B LIKE ?", "%"+B+"%")
+//	  C *int64 `gq:"gt=A"` db.Where("A < ?", C)
+//	  D *int64 `gq:"gte=A"` db.Where("A <= ?", D)
+//	  E *int64 `gq:"lt=A"` db.Where("A > ?", E)
+//	  F *int64 `gq:"lte=A"` db.Where("A >= ?", F)
+//	  G *int64 `gq:"neq"` db.Where("G != ?", G)
+//	  H []int64 `gq:"in"` db.Where("H IN ?", H)
+//	  I []int64 `gq:"nin"` db.Where("I NOT IN ?", I)
+//	  J string `gq:"prefix"` db.Where("J LIKE ?", J+"%")
+//	  K string `gq:"suffix"` db.Where("K LIKE ?", "%"+K)
+//	  L string `gq:"ilike"` db.Where("LOWER(L) LIKE LOWER(?)", "%"+L+"%")
+//	  M []int64 `gq:"between"` db.Where("M BETWEEN ? AND ?", M[0], M[1])
+//	  N *bool `gq:"null"` db.Where("N IS NULL") ，false 是 IS NOT NULL
+//	  O *bool `gq:"notnull"` db.Where("O IS NOT NULL") ，false 是 IS NULL
+//	  P *int64 `gq:"&"` db.Where("(P & ?) = ?", P, P) ，P 的位全部是 1
 //	}
-//
-// 先这样，以后遇到再加
 func GORMInitQuery(db *gorm.DB, q any) *gorm.DB {
 	v := reflect.ValueOf(q)
 	vk := v.Kind()
@@ -253,117 +312,48 @@ func GORMInitQuery(db *gorm.DB, q any) *gorm.DB {
 	return gormInitQuery(db, v)
 }
 
+// gormInitQuery 使用 v 的类型的解析结果，添加 v 的条件
 func gormInitQuery(db *gorm.DB, v reflect.Value) *gorm.DB {
-	vt := v.Type()
-	for i := 0; i < vt.NumField(); i++ {
-		fv := v.Field(i)
-		if !fv.IsValid() {
-			continue
-		}
-		fvk := fv.Kind()
-		if fvk == reflect.Pointer {
-			// 空指针
-			if fv.IsNil() {
-				continue
-			}
-			fv = fv.Elem()
-			fvk = fv.Kind()
-		}
-		// 结构
-		if fvk == reflect.Struct {
-			gormInitQuery(db, fv)
-			continue
-		}
-		if fvk == reflect.String {
-			// 空字符串
-			if fv.IsZero() {
-				continue
-			}
-		}
-		ft := vt.Field(i)
-		tn := ft.Tag.Get(GORMInitQueryTag)
-		p := strings.TrimPrefix(tn, "eq=")
-		if p != tn {
-			db = db.Where(fmt.Sprintf("`%s` = ?", p), fv.Interface())
-			continue
-		}
-		if tn == "eq" {
-			db = db.Where(fmt.Sprintf("`%s` = ?", ft.Name), fv.Interface())
-			continue
-		}
-		p = strings.TrimPrefix(tn, "neq=")
-		if p != tn {
-			db = db.Where(fmt.Sprintf("`%s` != ?", p), fv.Interface())
-			continue
-		}
-		if tn == "neq" {
-			db = db.Where(fmt.Sprintf("`%s` != ?", ft.Name), fv.Interface())
-			continue
-		}
-		p = strings.TrimPrefix(tn, "like=")
-		if p != tn {
-			db = db.Where(fmt.Sprintf("`%s` LIKE ?", p), fmt.Sprintf("%%%v%%", fv.Interface()))
-			continue
-		}
-		if tn == "like" {
-			db = db.Where(fmt.Sprintf("`%s` LIKE ?", ft.Name), fmt.Sprintf("%%%v%%", fv.Interface()))
-			continue
-		}
-		p = strings.TrimPrefix(tn, "gt=")
-		if p != tn {
-			db = db.Where(fmt.Sprintf("`%s` < ?", p), fv.Interface())
-			continue
-		}
-		if tn == "gt" {
-			db = db.Where(fmt.Sprintf("`%s` < ?", ft.Name), fv.Interface())
-			continue
-		}
-		p = strings.TrimPrefix(tn, "gte=")
-		if p != tn {
-			db = db.Where(fmt.Sprintf("`%s` <= ?", p), fv.Interface())
-			continue
-		}
-		if tn == "gte" {
-			db = db.Where(fmt.Sprintf("`%s` <= ?", ft.Name), fv.Interface())
-			continue
-		}
-		p = strings.TrimPrefix(tn, "lt=")
-		if p != tn {
-			db = db.Where(fmt.Sprintf("`%s` > ?", p), fv.Interface())
-			continue
-		}
-		if tn == "lt" {
-			db = db.Where(fmt.Sprintf("`%s` > ?", ft.Name), fv.Interface())
-			continue
-		}
-		p = strings.TrimPrefix(tn, "lte=")
-		if p != tn {
-			db = db.Where(fmt.Sprintf("`%s` >= ?", p), fv.Interface())
-			continue
-		}
-		if tn == "lte" {
-			db = db.Where(fmt.Sprintf("`%s` >= ?", ft.Name), fv.Interface())
-			continue
-		}
+	exprs, err := getGORMQueryPlan(v.Type()).exprs(v)
+	if err != nil {
+		db.AddError(err)
+		return db
+	}
+	for _, e := range exprs {
+		db = db.Where(e)
 	}
 	//
 	return db
 }
 
-// GORMList 分页查询
+// GORMList 分页查询，page.Order 只能使用 query 的 GORMOrderQuery 白名单，不合法返回 GORMOrderError 。
+// 和之前的版本不兼容，之前是原样排序，需要之前的行为 query 实现 GORMRawOrderQuery
 func GORMList[M any](db *gorm.DB, page *GORMListPage, query GORMQuery, res *GORMListData[M]) error {
+	return gormList(db, page, query, res, nil)
+}
+
+// gormList 是 GORMList ，last 是最后的排序
+func gormList[M any](db *gorm.DB, page *GORMListPage, query GORMQuery, res *GORMListData[M], last []clause.OrderByColumn) error {
+	// 排序，先检查，不合法不用查询
+	orders, err := gormListOrders(page, query)
+	if err != nil {
+		return err
+	}
 	// 条件
 	if query != nil {
 		db = query.Init(db)
 	}
-	// 总数
-	err := db.Count(&res.Total).Error
+	// 总数，Count 会修改 Joins 的 Select ，使用副本
+	err = db.Session(&gorm.Session{}).Count(&res.Total).Error
 	if err != nil {
 		return err
 	}
 	// 分页
 	if page != nil {
-		db = page.Init(db)
+		db = page.init(db, orders)
+	}
+	for _, o := range last {
+		db = db.Order(o)
 	}
 	// 查询
 	err = db.Find(&res.Data).Error
@@ -0,0 +1,559 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type testGORMQueryModel struct {
+	ID    int64   `gorm:"primaryKey"`
+	Name  string  `gorm:""`
+	Flags int64   `gorm:""`
+	Note  *string `gorm:""`
+}
+
+type testGORMQuery struct {
+	IDs      []int64   `gq:"in=ID"`
+	NotIDs   []int64   `gq:"nin=ID"`
+	Prefix   string    `gq:"prefix=Name"`
+	Suffix   string    `gq:"suffix=Name"`
+	Name     string    `gq:"ilike"`
+	Between  []int64   `gq:"between=ID"`
+	NoteNull *bool     `gq:"null=Note"`
+	HasNote  *bool     `gq:"notnull=Note"`
+	Flags    *int64    `gq:"&"`
+	Ignore   *int64    `gq:"-"`
+	Range    *[2]int64 `gq:"between=Flags"`
+}
+
+// testGORMQueryBad 的 tag 写错了，值是空的也返回错误
+type testGORMQueryBad struct {
+	Name    string  `gq:"ilike"`
+	Unknown *string `gq:"unknown=Name"`
+}
+
+func (q *testGORMQueryBad) Init(db *gorm.DB) *gorm.DB {
+	return GORMInitQuery(db, q)
+}
+
+func (q *testGORMQueryBad) MemoryQuery() {}
+
+// testGORMQueryBadGroup 的分组的 tag 写错了
+type testGORMQueryBadGroup struct {
+	Group struct {
+		IDs int64 `gq:"in=ID"`
+	}
+}
+
+func (q *testGORMQueryBadGroup) Init(db *gorm.DB) *gorm.DB {
+	return GORMInitQuery(db, q)
+}
+
+func (q *testGORMQueryBadGroup) MemoryQuery() {}
+
+func (q *testGORMQuery) Init(db *gorm.DB) *gorm.DB {
+	return GORMInitQuery(db, q)
+}
+
+func (q *testGORMQuery) MemoryQuery() {}
+
+func Test_GORMInitQuery(t *testing.T) {
+	db := newTestGORMDB(t)
+	err := db.AutoMigrate(new(testGORMQueryModel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []*testGORMQueryModel{
+		{ID: 1, Name: "Alice", Flags: 1, Note: testGORMPtr("a")},
+		{ID: 2, Name: "bob", Flags: 3},
+		{ID: 3, Name: "Carol", Flags: 6, Note: testGORMPtr("c")},
+		{ID: 4, Name: "dave", Flags: 7},
+	} {
+		err = db.Create(m).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := NewGORMCacheAuto[int64, *testGORMQueryModel](db, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.MemoryList = true
+	for _, p := range []struct {
+		name  string
+		query *testGORMQuery
+		ids   []int64
+	}{
+		{"empty", &testGORMQuery{IDs: []int64{}, Ignore: testGORMPtr[int64](1)}, []int64{1, 2, 3, 4}},
+		{"in", &testGORMQuery{IDs: []int64{1, 3, 5}}, []int64{1, 3}},
+		{"nin", &testGORMQuery{NotIDs: []int64{1, 3}}, []int64{2, 4}},
+		{"prefix", &testGORMQuery{Prefix: "Ca"}, []int64{3}},
+		{"suffix", &testGORMQuery{Suffix: "e"}, []int64{1, 4}},
+		{"ilike", &testGORMQuery{Name: "AL"}, []int64{1}},
+		{"between", &testGORMQuery{Between: []int64{2, 3}}, []int64{2, 3}},
+		{"between array", &testGORMQuery{Range: &[2]int64{3, 6}}, []int64{2, 3}},
+		{"null", &testGORMQuery{NoteNull: testGORMPtr(true)}, []int64{2, 4}},
+		{"null false", &testGORMQuery{NoteNull: testGORMPtr(false)}, []int64{1, 3}},
+		{"notnull", &testGORMQuery{HasNote: testGORMPtr(true)}, []int64{1, 3}},
+		{"notnull false", &testGORMQuery{HasNote: testGORMPtr(false)}, []int64{2, 4}},
+		{"&", &testGORMQuery{Flags: testGORMPtr[int64](3)}, []int64{2, 4}},
+		{"and", &testGORMQuery{NotIDs: []int64{4}, Flags: testGORMPtr[int64](2)}, []int64{2, 3}},
+	} {
+		// 数据库和内存
+		for _, cache := range []bool{false, true} {
+			c.Cache = cache
+			var res GORMListData[*testGORMQueryModel]
+			err = c.List(nil, p.query, &res)
+			if err != nil {
+				t.Fatal(p.name, err)
+			}
+			if len(res.Data) != len(p.ids) {
+				t.Fatal(p.name, cache, len(res.Data))
+			}
+			for i, m := range res.Data {
+				if m.ID != p.ids[i] {
+					t.Fatal(p.name, cache, m.ID)
+				}
+			}
+		}
+		ok, err := c.listMemory(context.Background(), nil, p.query, new(GORMListData[*testGORMQueryModel]))
+		if err != nil || !ok {
+			t.Fatal(p.name, "memory")
+		}
+	}
+	// 错误
+	for _, q := range []GORMQuery{
+		&testGORMQueryBad{Unknown: testGORMPtr("a")},
+		&testGORMQueryBad{},
+		&testGORMQueryBad{Name: "a"},
+		&testGORMQueryBadGroup{},
+		&testGORMQuery{Between: []int64{1}},
+	} {
+		var ms []*testGORMQueryModel
+		err = GORMInitQuery(db.Model(new(testGORMQueryModel)), q).Find(&ms).Error
+		if err == nil {
+			t.FailNow()
+		}
+		ok, _ := c.listMemory(context.Background(), nil, q, new(GORMListData[*testGORMQueryModel]))
+		if ok {
+			t.FailNow()
+		}
+		c.Cache = true
+		err = c.List(nil, q, new(GORMListData[*testGORMQueryModel]))
+		if err == nil {
+			t.FailNow()
+		}
+	}
+}
+
+type testGORMQueryAnd struct {
+	MinID *int64 `gq:"lte=ID"`
+	MaxID *int64 `gq:"gt=ID"`
+	Flags *int64 `gq:"&"`
+}
+
+type testGORMQueryGroup struct {
+	Keyword string `gq:"ilike=Name|Note"`
+	And     testGORMQueryAnd
+	Any     struct {
+		A testGORMQueryAnd
+		B *testGORMQueryAnd
+	} `gq:"or"`
+}
+
+func (q *testGORMQueryGroup) Init(db *gorm.DB) *gorm.DB {
+	return GORMInitQuery(db, q)
+}
+
+func (q *testGORMQueryGroup) MemoryQuery() {}
+
+func Test_GORMInitQueryGroup(t *testing.T) {
+	db := newTestGORMDB(t)
+	err := db.AutoMigrate(new(testGORMQueryModel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []*testGORMQueryModel{
+		{ID: 1, Name: "Alice", Flags: 1, Note: testGORMPtr("a")},
+		{ID: 2, Name: "bob", Flags: 3},
+		{ID: 3, Name: "Carol", Flags: 6, Note: testGORMPtr("c")},
+		{ID: 4, Name: "dave", Flags: 7},
+	} {
+		err = db.Create(m).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := NewGORMCacheAuto[int64, *testGORMQueryModel](db, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.MemoryList = true
+	or := func(q *testGORMQueryGroup) *testGORMQueryGroup {
+		q.Any.A = testGORMQueryAnd{MaxID: testGORMPtr[int64](2), Flags: testGORMPtr[int64](1)}
+		q.Any.B = &testGORMQueryAnd{MinID: testGORMPtr[int64](3), Flags: testGORMPtr[int64](6)}
+		return q
+	}
+	for _, p := range []struct {
+		name  string
+		query *testGORMQueryGroup
+		ids   []int64
+	}{
+		{"empty", &testGORMQueryGroup{}, []int64{1, 2, 3, 4}},
+		{"columns", &testGORMQueryGroup{Keyword: "c"}, []int64{1, 3}},
+		{"columns null", &testGORMQueryGroup{Keyword: "A"}, []int64{1, 3, 4}},
+		{"and", &testGORMQueryGroup{And: testGORMQueryAnd{MinID: testGORMPtr[int64](2)}}, []int64{2, 3, 4}},
+		{"or", or(&testGORMQueryGroup{}), []int64{1, 3, 4}},
+		{"or one", &testGORMQueryGroup{Keyword: "o", Any: struct {
+			A testGORMQueryAnd
+			B *testGORMQueryAnd
+		}{A: testGORMQueryAnd{MinID: testGORMPtr[int64](3)}}}, []int64{3}},
+		{"all", or(&testGORMQueryGroup{Keyword: "o"}), []int64{3}},
+	} {
+		// 数据库和内存
+		for _, cache := range []bool{false, true} {
+			c.Cache = cache
+			var res GORMListData[*testGORMQueryModel]
+			err = c.List(nil, p.query, &res)
+			if err != nil {
+				t.Fatal(p.name, err)
+			}
+			if len(res.Data) != len(p.ids) {
+				t.Fatal(p.name, cache, len(res.Data))
+			}
+			for i, m := range res.Data {
+				if m.ID != p.ids[i] {
+					t.Fatal(p.name, cache, m.ID)
+				}
+			}
+		}
+		ok, err := c.listMemory(context.Background(), nil, p.query, new(GORMListData[*testGORMQueryModel]))
+		if err != nil || !ok {
+			t.Fatal(p.name, "memory")
+		}
+	}
+	// 语句
+	sql := db.ToSQL(func(tx *gorm.DB) *gorm.DB {
+		var ms []*testGORMQueryModel
+		return GORMInitQuery(tx.Model(new(testGORMQueryModel)), or(&testGORMQueryGroup{Keyword: "o"})).Find(&ms)
+	})
+	if !strings.Contains(sql, "WHERE (LOWER(`Name`) LIKE LOWER(\"%o%\") OR LOWER(`Note`) LIKE LOWER(\"%o%\")) AND "+
+		"((`ID` < 2 AND (`Flags` & 1) = 1) OR (`ID` >= 3 AND (`Flags` & 6) = 6))") {
+		t.Fatal(sql)
+	}
+}
+
+// testGORMQuoteDialector 使用双引号，和 PostgreSQL 一样
+type testGORMQuoteDialector struct {
+	gorm.Dialector
+}
+
+func (d testGORMQuoteDialector) QuoteTo(w clause.Writer, s string) {
+	for i, p := range strings.Split(s, ".") {
+		if i > 0 {
+			w.WriteByte('.')
+		}
+		w.WriteByte('"')
+		w.WriteString(p)
+		w.WriteByte('"')
+	}
+}
+
+type testGORMQueryQuote struct {
+	Name  string   `gq:"like=testGORMQueryModel.Name|Note"`
+	IDs   []int64  `gq:"in=ID"`
+	Flags *int64   `gq:"&"`
+	Null  *bool    `gq:"null=Note"`
+	Range []int64  `gq:"between=ID"`
+	Alias *string  `gq:"eq=名称"`
+	Skip  *float64 `gq:"-"`
+}
+
+func Test_GORMInitQueryQuote(t *testing.T) {
+	db, err := gorm.Open(testGORMQuoteDialector{sqlite.Open(":memory:")},
+		&gorm.Config{DryRun: true, NamingStrategy: NewGORMConfig().NamingStrategy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := &testGORMQueryQuote{
+		Name:  "a",
+		IDs:   []int64{1, 2},
+		Flags: testGORMPtr[int64](3),
+		Null:  testGORMPtr(false),
+		Range: []int64{1, 9},
+		Alias: testGORMPtr("b"),
+	}
+	var ms []*testGORMQueryModel
+	tx := GORMInitQuery(db.Model(new(testGORMQueryModel)), q).Find(&ms)
+	if tx.Error != nil {
+		t.Fatal(tx.Error)
+	}
+	sql := tx.Statement.SQL.String()
+	if strings.Contains(sql, "`") ||
+		!strings.Contains(sql, `WHERE ("testGORMQueryModel"."Name" LIKE ? OR "Note" LIKE ?) AND "ID" IN (?,?) AND `+
+			`("Flags" & ?) = ? AND "Note" IS NOT NULL AND ("ID" BETWEEN ? AND ?) AND "名称" = ?`) {
+		t.Fatal(sql)
+	}
+	// WhereID 和 WhereIDs
+	tx = WhereIDs(db.Model(new(testGORMQueryModel)), []int64{1, 2}).Find(&ms)
+	sql = tx.Statement.SQL.String()
+	if !strings.Contains(sql, `WHERE "testGORMQueryModel"."ID" IN (?,?)`) {
+		t.Fatal(sql)
+	}
+	tx = WhereID(db.Model(new(testGORMQueryModel)), int64(1)).Find(&ms)
+	sql = tx.Statement.SQL.String()
+	if !strings.Contains(sql, `WHERE "testGORMQueryModel"."ID" = ?`) {
+		t.Fatal(sql)
+	}
+}
+
+type testGORMQueryTag struct {
+	Name string `gq:"eq=Name" gq2:"eq=Note"`
+}
+
+func Test_GORMInitQueryTag(t *testing.T) {
+	db, err := gorm.Open(testGORMQuoteDialector{sqlite.Open(":memory:")},
+		&gorm.Config{DryRun: true, NamingStrategy: NewGORMConfig().NamingStrategy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	find := func() string {
+		var ms []*testGORMQueryModel
+		tx := GORMInitQuery(db.Model(new(testGORMQueryModel)), &testGORMQueryTag{Name: "a"}).Find(&ms)
+		if tx.Error != nil {
+			t.Fatal(tx.Error)
+		}
+		return tx.Statement.SQL.String()
+	}
+	if sql := find(); !strings.Contains(sql, `WHERE "Name" = ?`) {
+		t.Fatal(sql)
+	}
+	// 使用过后修改 tag
+	defer func(tag string) { GORMInitQueryTag = tag }(GORMInitQueryTag)
+	GORMInitQueryTag = "gq2"
+	if sql := find(); !strings.Contains(sql, `WHERE "Note" = ?`) {
+		t.Fatal(sql)
+	}
+}
+
+type testGORMQueryInject struct {
+	Name string `gq:"eq=Name; DROP TABLE testGORMQueryModel"`
+}
+
+func Test_isGORMIdentifier(t *testing.T) {
+	for _, s := range []string{"ID", "_a1", "User.Name", "名称"} {
+		if !isGORMIdentifier(s) {
+			t.Fatal(s)
+		}
+	}
+	for _, s := range []string{"", "1a", "a.b.c", ".a", "a.", "a b", "a`", `a"`, "a;", "a)", "a-b"} {
+		if isGORMIdentifier(s) {
+			t.Fatal(s)
+		}
+	}
+	// 使用的时候返回错误
+	db := newTestGORMDB(t)
+	var ms []*testGORMQueryModel
+	err := GORMInitQuery(db.Model(new(testGORMQueryModel)), &testGORMQueryInject{Name: "a"}).Find(&ms).Error
+	if err == nil || !strings.Contains(err.Error(), "invalid column") {
+		t.Fatal(err)
+	}
+}
+
+type testGORMBenchQuery struct {
+	IDs     []int64  `gq:"in=ID"`
+	NotIDs  []int64  `gq:"nin=ID"`
+	Name    string   `gq:"like"`
+	Phone   *string  `gq:"eq"`
+	Keyword string   `gq:"ilike=Name|Phone"`
+	Prefix  string   `gq:"prefix=Name"`
+	Suffix  string   `gq:"suffix=Phone"`
+	MinID   *int64   `gq:"lte=ID"`
+	MaxID   *int64   `gq:"gt=ID"`
+	Created []int64  `gq:"between=CreatedAt"`
+	Updated *int64   `gq:"gte=UpdatedAt"`
+	NotName string   `gq:"neq=Name"`
+	Deleted *bool    `gq:"null=DeletedAt"`
+	Flags   *int64   `gq:"&=ID"`
+	Ignore  *float64 `gq:"-"`
+}
+
+func newTestGORMBenchQuery() *testGORMBenchQuery {
+	return &testGORMBenchQuery{
+		IDs:     []int64{1, 2, 3},
+		NotIDs:  []int64{4},
+		Name:    "name",
+		Phone:   testGORMPtr("phone"),
+		Keyword: "key",
+		Prefix:  "na",
+		Suffix:  "1",
+		MinID:   testGORMPtr[int64](1),
+		MaxID:   testGORMPtr[int64](100),
+		Created: []int64{1, 2},
+		Updated: testGORMPtr[int64](3),
+		NotName: "abc",
+		Deleted: testGORMPtr(true),
+		Flags:   testGORMPtr[int64](1),
+	}
+}
+
+func Benchmark_GORMInitQuery(b *testing.B) {
+	db := newTestGORMDB(b)
+	q := newTestGORMBenchQuery()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		GORMInitQuery(db.Model(new(testGORMModel)), q)
+	}
+}
+
+// Benchmark_GORMInitQueryNoCache 使用之前的实现，每次都解析 tag ，用于比较
+func Benchmark_GORMInitQueryNoCache(b *testing.B) {
+	db := newTestGORMDB(b)
+	v := reflect.ValueOf(newTestGORMBenchQuery()).Elem()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tx := db.Model(new(testGORMModel))
+		exprs, err := testGORMQueryExprs(v)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, e := range exprs {
+			tx = tx.Where(e)
+		}
+	}
+}
+
+// testGORMQueryExprs 是没有缓存解析结果之前的 gormQueryExprs ，
+// 每次都遍历字段和解析 tag ，只用于 Benchmark_GORMInitQueryNoCache 比较
+func testGORMQueryExprs(v reflect.Value) ([]clause.Expression, error) {
+	var exprs []clause.Expression
+	vt := v.Type()
+	for i := 0; i < vt.NumField(); i++ {
+		fv := v.Field(i)
+		if !fv.IsValid() {
+			continue
+		}
+		fvk := fv.Kind()
+		if fvk == reflect.Pointer {
+			// 空指针
+			if fv.IsNil() {
+				continue
+			}
+			fv = fv.Elem()
+			fvk = fv.Kind()
+		}
+		ft := vt.Field(i)
+		tn := ft.Tag.Get(GORMInitQueryTag)
+		if tn == "-" {
+			continue
+		}
+		// 结构，分组
+		if fvk == reflect.Struct && (tn == "" || tn == "and" || tn == "or") {
+			es, err := testGORMQueryExprs(fv)
+			if err != nil {
+				return nil, err
+			}
+			if len(es) < 1 {
+				continue
+			}
+			if tn == "or" {
+				exprs = append(exprs, testGORMQueryOr(es))
+			} else {
+				exprs = append(exprs, clause.And(es...))
+			}
+			continue
+		}
+		if tn == "" {
+			continue
+		}
+		if fvk == reflect.String || fvk == reflect.Slice {
+			// 空字符串，空切片
+			if fv.Len() < 1 {
+				continue
+			}
+		}
+		op, p, _ := strings.Cut(tn, "=")
+		if p == "" {
+			p = ft.Name
+		}
+		// 多个列是 OR
+		var es []clause.Expression
+		for _, p := range strings.Split(p, "|") {
+			e, err := testGORMQueryExpr(ft.Name, op, p, fv)
+			if err != nil {
+				return nil, err
+			}
+			es = append(es, e)
+		}
+		exprs = append(exprs, testGORMQueryOr(es))
+	}
+	return exprs, nil
+}
+
+// testGORMQueryOr 是之前的 gormQueryOr
+func testGORMQueryOr(exprs []clause.Expression) clause.Expression {
+	if len(exprs) == 1 {
+		return exprs[0]
+	}
+	return clause.Or(exprs...)
+}
+
+// testGORMQueryExpr 是之前的 gormQueryExpr
+func testGORMQueryExpr(name, op, p string, fv reflect.Value) (clause.Expression, error) {
+	fvk := fv.Kind()
+	switch op {
+	case "eq":
+		return clause.Expr{SQL: fmt.Sprintf("`%s` = ?", p), Vars: []any{fv.Interface()}}, nil
+	case "neq":
+		return clause.Expr{SQL: fmt.Sprintf("`%s` != ?", p), Vars: []any{fv.Interface()}}, nil
+	case "like":
+		return clause.Expr{SQL: fmt.Sprintf("`%s` LIKE ?", p), Vars: []any{fmt.Sprintf("%%%v%%", fv.Interface())}}, nil
+	case "ilike":
+		return clause.Expr{SQL: fmt.Sprintf("LOWER(`%s`) LIKE LOWER(?)", p), Vars: []any{fmt.Sprintf("%%%v%%", fv.Interface())}}, nil
+	case "prefix":
+		return clause.Expr{SQL: fmt.Sprintf("`%s` LIKE ?", p), Vars: []any{fmt.Sprintf("%v%%", fv.Interface())}}, nil
+	case "suffix":
+		return clause.Expr{SQL: fmt.Sprintf("`%s` LIKE ?", p), Vars: []any{fmt.Sprintf("%%%v", fv.Interface())}}, nil
+	case "gt":
+		return clause.Expr{SQL: fmt.Sprintf("`%s` < ?", p), Vars: []any{fv.Interface()}}, nil
+	case "gte":
+		return clause.Expr{SQL: fmt.Sprintf("`%s` <= ?", p), Vars: []any{fv.Interface()}}, nil
+	case "lt":
+		return clause.Expr{SQL: fmt.Sprintf("`%s` > ?", p), Vars: []any{fv.Interface()}}, nil
+	case "lte":
+		return clause.Expr{SQL: fmt.Sprintf("`%s` >= ?", p), Vars: []any{fv.Interface()}}, nil
+	case "in", "nin":
+		if fvk != reflect.Slice && fvk != reflect.Array {
+			return nil, fmt.Errorf("gq field %s must be slice", name)
+		}
+		if op == "in" {
+			return clause.Expr{SQL: fmt.Sprintf("`%s` IN ?", p), Vars: []any{fv.Interface()}}, nil
+		}
+		return clause.Expr{SQL: fmt.Sprintf("`%s` NOT IN ?", p), Vars: []any{fv.Interface()}}, nil
+	case "between":
+		if (fvk != reflect.Slice && fvk != reflect.Array) || fv.Len() != 2 {
+			return nil, fmt.Errorf("gq field %s must be slice of 2", name)
+		}
+		return clause.Expr{SQL: fmt.Sprintf("`%s` BETWEEN ? AND ?", p), Vars: []any{fv.Index(0).Interface(), fv.Index(1).Interface()}}, nil
+	case "null", "notnull":
+		if fvk != reflect.Bool {
+			return nil, fmt.Errorf("gq field %s must be bool", name)
+		}
+		if fv.Bool() == (op == "null") {
+			return clause.Expr{SQL: fmt.Sprintf("`%s` IS NULL", p)}, nil
+		}
+		return clause.Expr{SQL: fmt.Sprintf("`%s` IS NOT NULL", p)}, nil
+	case "&":
+		return clause.Expr{SQL: fmt.Sprintf("(`%s` & ?) = ?", p), Vars: []any{fv.Interface(), fv.Interface()}}, nil
+	}
+	return nil, fmt.Errorf("gq field %s unknown tag %s", name, op)
+}
@@ -2,19 +2,45 @@ package util
 
 import (
 	"context"
+	"errors"
+	"reflect"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+var (
+	// GORMCacheBatchSize 是有限模式下分批查询数据库的数量
+	GORMCacheBatchSize = 1000
+	// GORMCacheLoadTimeout 是合并的单个查询的超时，
+	// 合并的查询不使用调用者的 ctx ，一个调用者取消不影响其他的
+	GORMCacheLoadTimeout = time.Second * 30
+	// errGORMCacheStop 用于停止分批查询
+	errGORMCacheStop = errors.New("stop")
+	// ErrGORMCacheNotFound 表示数据不存在
+	ErrGORMCacheNotFound = errors.New("gorm cache record not found")
 )
 
 // GORMCache 用于缓存数据
+// 读操作使用读锁，相互之间不阻塞。
+// 查询数据库的时候不持有读写锁，只在替换数据的时候短暂上写锁，
+// 所以重新加载的时候，读操作读取的是旧的数据。
 type GORMCache[K comparable, M any] struct {
-	sync.Mutex
+	// 保护 D 和 OK
+	sync.RWMutex
+	// 加载锁，保证同一时间只有一个加载，防止相互覆盖。
+	// 按需加载单个不使用，使用 flight 和 epoch
+	loadLock sync.Mutex
 	// 数据库
 	DB *gorm.DB
 	// 是否开启缓存
 	Cache bool
-	// 数据
+	// 数据，全部加载的时候会替换成新的 map
 	D map[K]M
 	// gorm.Model 要的模型
 	M M
@@ -24,10 +50,105 @@ type GORMCache[K comparable, M any] struct {
 	New func() M
 	// 返回 M 的主键
 	Key func(M) K
+	// 在内存中执行 ListWithContext 的条件，排序和分页，没有全部加载的时候使用数据库。
+	// query 需要实现 GORMMemoryQuery ，表示 Init 只调用了 GORMInitQuery ，
+	// page 的 Order 的列不能带上表名，不支持的使用数据库。最后都按照主键排序，两种方式的结果一致。
+	// 注意，字符串比较使用 sqlite 的默认规则，区分大小写，LIKE 的 ASCII 字母不区分大小写
+	MemoryList bool
+	// 拷贝 M ，不为 nil 时，读取返回的都是副本，可以随意修改，
+	// UpdateCache 也是修改副本后替换，内存中的数据不会被原地修改。
+	// 可以使用 GORMCacheDeepCopy
+	Clone func(M) M
 	// 返回 M 的主键，用于单个，查询，修改，删除
 	WhereKey func(*gorm.DB, K) *gorm.DB
 	// 返回 M 的主键列表，用于批量删除
 	WhereKeys func(*gorm.DB, []K) *gorm.DB
+	// 不为 nil 时，ModelWithContext 使用它添加条件，加载和查询都只包含满足条件的数据，
+	// 比如只加载一个租户的数据，参考 GORMPartitionCache
+	Scope func(*gorm.DB) *gorm.DB
+	// 加载数据时 Preload 的关联，比如 "Orders" 和 "Orders.Items" ，
+	// 关联修改后可以使用 GORMCacheRefer 重新加载
+	Preload []string
+	// 加载数据时 Joins 的关联，只能是 belongs to 和 has one 。
+	// 关联表有相同的列名时，WhereKey ，WhereKeys 和 Scope 的列名需要带上表名，
+	// 比如 clause.Column{Table: clause.CurrentTable, Name: "ID"} ，WhereID 和 WhereIDs 已经带上了
+	Joins []string
+	// 乐观锁的版本字段名称，比如 "Version" 或者 "UpdatedAt" ，为空不使用。
+	// 不为空时，更新和保存添加版本的条件，版本不一致返回 GORMConflictError ，
+	// 并重新加载数据，批量的有一个冲突全部回滚
+	Version string
+	// 单个数据的有效时间，小于等于 0 不过期。
+	// 在 Get 的时候检查，过期则重新加载单个
+	TTL time.Duration
+	// 单个数据的过期时间戳
+	exp map[K]int64
+	// 用于退出后台协程
+	quit *Signal
+	// 用于等待后台协程
+	wait sync.WaitGroup
+	// 最大的缓存数量，大于 0 是有限模式，不会全部加载。
+	// Get 没有命中的时候从数据库加载单个，超出数量按照 Evict 淘汰，
+	// 其他需要全部数据的查询直接使用数据库
+	Max int
+	// 有限模式的淘汰策略
+	Evict GORMCacheEvict
+	// 有限模式的淘汰记录
+	evictor gormCacheEvictor[K]
+	// 大于 0 时缓存数据库中不存在的 key ，在有效时间内不再查询数据库。
+	// 用于不启用缓存，或者有限模式
+	NotFoundTTL time.Duration
+	// 不存在的 key 的过期时间戳
+	nf map[K]int64
+	// nf 的数量超过这个值时，清理过期的
+	nfSweep int
+	// 合并相同 key 的并发查询
+	flight SingleFlight[K, M]
+	// 消息总线，用于多个进程之间同步
+	bus GORMCacheBus
+	// 在总线上的名称
+	busName string
+	// 在总线上的节点
+	busNode string
+	// 取消订阅
+	busCancel func()
+	// 二级索引
+	index map[string]*gormCacheIndex[K, M]
+	// 统计
+	stats gormCacheStats
+	// 数据加载到内存后回调，在锁外回调，包括加载锁，可以再调用 GORMCache 的函数
+	OnLoad func(K, M)
+	// 数据从内存中移除后回调，包括删除，淘汰和全部加载时数据库已经没有的，
+	// 在锁外回调，包括加载锁，可以再调用 GORMCache 的函数
+	OnEvict func(K, M)
+	// 加载失败后回调，全部加载失败时 OK 已经标记为 false ，
+	// 单个数据刷新失败保留旧的数据，ctx 取消或者超时不回调。
+	// 在锁外回调，包括加载锁，可以再调用 GORMCache 的函数
+	OnReloadError func(error)
+	// 写锁期间记录的事件，解锁后回调
+	events []gormCacheEvent[K, M]
+	// 延迟写，还没有写入数据库的数据
+	dirty map[K]M
+	// 延迟写，正在写入数据库的数据
+	flushing map[K]M
+	// 保证同一时间只有一个写入
+	flushLock sync.Mutex
+	// 延迟写的配置
+	behind gormCacheBehind[K]
+	// 保护 subs
+	subLock sync.RWMutex
+	// 订阅
+	subs map[*GORMCacheSubscription[K, M]]struct{}
+	// 订阅的数量，没有订阅不记录修改
+	subN atomic.Int32
+	// 写锁期间记录的修改，解锁后发送
+	changes []*GORMCacheChange[K, M]
+	// 正在加载，期间记录的事件和修改在解加载锁之后回调，
+	// 这样回调里面可以再调用加载的函数
+	loading     bool
+	loadEvents  []gormCacheEvent[K, M]
+	loadChanges []*GORMCacheChange[K, M]
+	// 上加载锁的次数，按需加载期间有其他的加载，查询的结果可能是旧的，不保存
+	epoch uint64
 }
 
 // NewGORMCache 返回新的缓存，enable 为 false 则不开启缓存
@@ -56,6 +177,9 @@ func (c *GORMCache[K, M]) Init(
 	c.DB = db
 	c.Cache = cache
 	c.D = make(map[K]M)
+	c.exp = make(map[K]int64)
+	c.nf = make(map[K]int64)
+	c.quit = NewSignal()
 	c.New = newFunc
 	c.Key = keyFunc
 	c.WhereKey = whereKeyFunc
@@ -65,63 +189,506 @@ func (c *GORMCache[K, M]) Init(
 
 // Model 返回加载模型的 db
 func (c *GORMCache[K, M]) Model() *gorm.DB {
-	return c.DB.Model(c.M)
+	return c.ModelWithContext(context.Background())
 }
 
-// ModelWithContext 返回加载模型的 db
+// ModelWithContext 返回加载模型的 db ，设置了 Scope 会添加它的条件
 func (c *GORMCache[K, M]) ModelWithContext(ctx context.Context) *gorm.DB {
-	return c.DB.Model(c.M).WithContext(ctx)
+	return c.scoped(c.DB.Model(c.M).WithContext(ctx))
+}
+
+// loadModel 返回加载数据的 db ，添加了 Preload 和 Joins
+func (c *GORMCache[K, M]) loadModel(ctx context.Context) *gorm.DB {
+	db := c.ModelWithContext(ctx)
+	for _, s := range c.Joins {
+		db = db.Joins(s)
+	}
+	for _, s := range c.Preload {
+		db = db.Preload(s)
+	}
+	return db
+}
+
+// scoped 设置了 Scope 返回添加了条件的 db
+func (c *GORMCache[K, M]) scoped(db *gorm.DB) *gorm.DB {
+	if c.Scope != nil {
+		db = c.Scope(db)
+	}
+	return db
+}
+
+// schema 返回模型的 gorm schema
+func (c *GORMCache[K, M]) schema() (*schema.Schema, error) {
+	stmt := &gorm.Statement{DB: c.DB}
+	err := stmt.Parse(c.M)
+	return stmt.Schema, err
+}
+
+// isOK 返回 OK
+func (c *GORMCache[K, M]) isOK() bool {
+	c.RLock()
+	ok := c.OK
+	c.RUnlock()
+	return ok
+}
+
+// loadError 加载失败，标记 OK 为 false 并回调，
+// ctx 取消或者超时不是数据的问题，不标记
+func (c *GORMCache[K, M]) loadError(err error) {
+	if isContextError(err) {
+		return
+	}
+	c.stats.reloadErrors.Add(1)
+	c.Lock()
+	c.OK = false
+	c.reloadError(err)
+	c.unlock()
+}
+
+// keyError 单个数据刷新失败，保留旧的数据，下次读取再刷新，不影响其他数据
+func (c *GORMCache[K, M]) keyError(err error) {
+	if isContextError(err) {
+		return
+	}
+	c.stats.reloadErrors.Add(1)
+	c.Lock()
+	c.reloadError(err)
+	c.unlock()
+}
+
+// reloadError 记录加载失败的事件，需要先上写锁
+func (c *GORMCache[K, M]) reloadError(err error) {
+	if c.OnReloadError != nil {
+		c.events = append(c.events, gormCacheEvent[K, M]{err: err})
+	}
+}
+
+// isContextError 返回 err 是否 ctx 取消或者超时
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// unlock 解写锁，然后回调写锁期间记录的事件，
+// 正在加载的时候，等到 unlockLoad 再回调
+func (c *GORMCache[K, M]) unlock() {
+	es := c.events
+	c.events = nil
+	cs := c.changes
+	c.changes = nil
+	if c.loading {
+		c.loadEvents = append(c.loadEvents, es...)
+		c.loadChanges = append(c.loadChanges, cs...)
+		c.Unlock()
+		return
+	}
+	c.Unlock()
+	c.callback(es, cs)
+}
+
+// lockLoad 上加载锁，之后的回调等到 unlockLoad
+func (c *GORMCache[K, M]) lockLoad() {
+	c.loadLock.Lock()
+	c.Lock()
+	c.loading = true
+	c.epoch++
+	c.Unlock()
 }
 
-// loadOne 加载单个，添加和修改时候调用，db 在外面初始化好
-// 注意返回错误，要设置 ok 为 false
-func (c *GORMCache[K, M]) loadOne(db *gorm.DB, k K) error {
+// unlockLoad 解加载锁，然后回调加载期间记录的事件
+func (c *GORMCache[K, M]) unlockLoad() {
+	c.Lock()
+	c.loading = false
+	es := c.loadEvents
+	c.loadEvents = nil
+	cs := c.loadChanges
+	c.loadChanges = nil
+	c.Unlock()
+	c.loadLock.Unlock()
+	c.callback(es, cs)
+}
+
+// callback 发送修改，回调事件，不能上锁
+func (c *GORMCache[K, M]) callback(es []gormCacheEvent[K, M], cs []*GORMCacheChange[K, M]) {
+	c.notify(cs)
+	for _, e := range es {
+		switch {
+		case e.err != nil:
+			c.OnReloadError(e.err)
+		case e.load:
+			c.OnLoad(e.k, e.m)
+		default:
+			c.OnEvict(e.k, e.m)
+		}
+	}
+}
+
+// bounded 返回是否有限模式
+func (c *GORMCache[K, M]) bounded() bool {
+	return c.Max > 0
+}
+
+// set 设置数据，需要先上写锁
+func (c *GORMCache[K, M]) set(k K, m M) {
+	// 还没有写入数据库的修改
+	if v, ok := c.pending(k); ok {
+		m = v
+	}
+	if c.subN.Load() > 0 {
+		if old, ok := c.D[k]; !ok {
+			var zero M
+			c.change(GORMCacheOpAdd, k, zero, m)
+		} else if !reflect.DeepEqual(old, m) {
+			c.change(GORMCacheOpUpdate, k, old, m)
+		}
+	}
+	c.D[k] = m
+	if c.OnLoad != nil {
+		c.events = append(c.events, gormCacheEvent[K, M]{load: true, k: k, m: m})
+	}
+	delete(c.nf, k)
+	if c.TTL > 0 {
+		c.exp[k] = time.Now().Add(c.TTL).UnixNano()
+	}
+	c.indexSet(k, m)
+	// 有限模式
+	if c.bounded() {
+		if c.evictor == nil {
+			c.evictor = newGORMCacheEvictor[K](c.Evict)
+		}
+		c.evictor.touch(k)
+		// 淘汰
+		for len(c.D) > c.Max {
+			k, ok := c.evictor.evict()
+			if !ok {
+				break
+			}
+			c.remove(k, GORMCacheOpEvict)
+			c.stats.evictions.Add(1)
+		}
+	}
+}
+
+// clear 清空数据，需要先上写锁
+func (c *GORMCache[K, M]) clear() {
+	var zero M
+	for k, m := range c.D {
+		if c.OnEvict != nil {
+			c.events = append(c.events, gormCacheEvent[K, M]{k: k, m: m})
+		}
+		c.change(GORMCacheOpEvict, k, m, zero)
+	}
+	c.D = make(map[K]M)
+	c.exp = make(map[K]int64)
+	c.nf = make(map[K]int64)
+	if c.evictor != nil {
+		c.evictor.reset()
+	}
+	c.indexReset()
+}
+
+// del 删除数据，需要先上写锁
+func (c *GORMCache[K, M]) del(k K) {
+	c.remove(k, GORMCacheOpDelete)
+}
+
+// remove 删除数据，op 是通知订阅的类型，需要先上写锁
+func (c *GORMCache[K, M]) remove(k K, op GORMCacheOp) {
+	if m, ok := c.D[k]; ok {
+		if c.OnEvict != nil {
+			c.events = append(c.events, gormCacheEvent[K, M]{k: k, m: m})
+		}
+		var zero M
+		c.change(op, k, m, zero)
+	}
+	delete(c.D, k)
+	delete(c.exp, k)
+	if c.evictor != nil {
+		c.evictor.del(k)
+	}
+	c.indexDel(k)
+}
+
+// setNotFound 记录不存在的 key ，需要先上写锁
+func (c *GORMCache[K, M]) setNotFound(k K) {
+	if c.NotFoundTTL <= 0 {
+		return
+	}
+	// 清理过期的
+	if len(c.nf) >= c.nfSweep {
+		now := time.Now().UnixNano()
+		for k, t := range c.nf {
+			if now > t {
+				delete(c.nf, k)
+			}
+		}
+		c.nfSweep = len(c.nf)*2 + GORMCacheBatchSize
+	}
+	c.nf[k] = time.Now().Add(c.NotFoundTTL).UnixNano()
+}
+
+// isNotFound 返回 key 是否记录为不存在，需要先上读锁
+func (c *GORMCache[K, M]) isNotFound(k K) bool {
+	t, ok := c.nf[k]
+	return ok && time.Now().UnixNano() <= t
+}
+
+// expired 返回数据是否过期，需要先上读锁
+func (c *GORMCache[K, M]) expired(k K) bool {
+	return c.TTL > 0 && time.Now().UnixNano() > c.exp[k]
+}
+
+// rlock 确保数据有效后上读锁，返回错误则没有上锁
+func (c *GORMCache[K, M]) rlock(ctx context.Context) error {
+	c.RLock()
+	if c.OK {
+		return nil
+	}
+	c.RUnlock()
+	// 加载
+	c.lockLoad()
+	err := c.check(c.loadModel(ctx))
+	c.unlockLoad()
+	if err != nil {
+		return err
+	}
+	c.RLock()
+	//
+	return nil
+}
+
+// loadOne 加载单个并返回，添加和修改时候调用，db 在外面初始化好
+// 需要先上加载锁，注意返回错误，要设置 ok 为 false
+func (c *GORMCache[K, M]) loadOne(db *gorm.DB, k K) (m M, ok bool, err error) {
+	defer c.stats.observe(time.Now(), &c.stats.partialReloads, &err)
 	// 读取
-	m := c.New()
-	err := c.WhereKey(db, k).First(m).Error
+	mm := c.New()
+	err = c.WhereKey(db, k).First(mm).Error
 	// 失败
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return nil
+			// 数据库已经没有了
+			c.Lock()
+			c.del(k)
+			if c.bounded() {
+				c.setNotFound(k)
+			}
+			c.unlock()
+			err = nil
 		}
-		return err
+		return
 	}
 	// 成功
-	c.D[k] = m
+	c.Lock()
+	c.set(k, mm)
+	c.unlock()
+	m = mm
+	ok = true
 	//
-	return nil
+	return
+}
+
+// loadLazy 按需加载单个并返回，不需要上加载锁，
+// epoch 是查询之前的 c.epoch ，期间有其他的加载，结果可能比内存中的旧，只返回不保存
+func (c *GORMCache[K, M]) loadLazy(db *gorm.DB, k K, epoch uint64) (m M, ok bool, err error) {
+	defer c.stats.observe(time.Now(), &c.stats.partialReloads, &err)
+	// 读取
+	mm := c.New()
+	err = c.WhereKey(db, k).First(mm).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return
+	}
+	c.Lock()
+	save := c.epoch == epoch && !c.loading
+	if err != nil {
+		// 数据库已经没有了
+		if save {
+			c.del(k)
+			if c.bounded() {
+				c.setNotFound(k)
+			}
+		}
+		c.unlock()
+		err = nil
+		return
+	}
+	if save {
+		c.set(k, mm)
+	} else if v, ok := c.pending(k); ok {
+		// 还没有写入数据库的修改
+		mm = v
+	}
+	c.unlock()
+	m = mm
+	ok = true
+	//
+	return
 }
 
-// loadMultiple 加载多个，db 在外面初始化好
-func (c *GORMCache[K, M]) loadMultiple(db *gorm.DB) error {
+// loadKey 从数据库加载单个并返回，不存在返回 ErrGORMCacheNotFound ，
+// 失败的时候保留旧的数据，不标记 OK 。
+// 相同 key 的并发调用只查询一次，不同的 key 相互不阻塞，ctx 取消只是不再等待
+func (c *GORMCache[K, M]) loadKey(ctx context.Context, k K) (M, error) {
+	return c.flight.DoContext(ctx, k, func() (m M, err error) {
+		c.RLock()
+		epoch := c.epoch
+		c.RUnlock()
+		ctx, cancel := context.WithTimeout(context.Background(), GORMCacheLoadTimeout)
+		defer cancel()
+		// 加载
+		m, ok, err := c.loadLazy(c.loadModel(ctx), k, epoch)
+		if err != nil {
+			// 只是这一个失败，过期的数据还在，下次读取再刷新
+			c.keyError(err)
+		} else if !ok {
+			err = ErrGORMCacheNotFound
+		}
+		//
+		return
+	})
+}
+
+// firstDB 不启用缓存时，从数据库查询单个，不存在返回 ErrGORMCacheNotFound
+// 相同 key 的并发调用只查询一次，ctx 取消只是不再等待
+func (c *GORMCache[K, M]) firstDB(ctx context.Context, k K) (M, error) {
+	// 不存在的记录
+	if c.NotFoundTTL > 0 {
+		c.RLock()
+		nf := c.isNotFound(k)
+		c.RUnlock()
+		if nf {
+			var m M
+			return m, ErrGORMCacheNotFound
+		}
+	}
+	// 数据库
+	return c.flight.DoContext(ctx, k, func() (m M, err error) {
+		ctx, cancel := context.WithTimeout(context.Background(), GORMCacheLoadTimeout)
+		defer cancel()
+		mm := c.New()
+		err = c.WhereKey(c.loadModel(ctx), k).First(mm).Error
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				c.Lock()
+				c.setNotFound(k)
+				c.unlock()
+				err = ErrGORMCacheNotFound
+			}
+			return
+		}
+		m = mm
+		//
+		return
+	})
+}
+
+// loadMultiple 加载多个，合并到原来的数据，db 在外面初始化好
+// 需要先上加载锁
+func (c *GORMCache[K, M]) loadMultiple(db *gorm.DB) (err error) {
+	defer c.stats.observe(time.Now(), &c.stats.partialReloads, &err)
 	// 查询
 	var ms []M
-	err := db.Find(&ms).Error
+	err = db.Find(&ms).Error
 	if err != nil {
 		return err
 	}
 	// 加载或替换
+	c.Lock()
 	for _, m := range ms {
-		c.D[c.Key(m)] = m
+		c.set(c.Key(m), m)
+	}
+	c.unlock()
+	//
+	return nil
+}
+
+// loadAll 全部加载，替换原来的数据，并设置 OK ，db 在外面初始化好
+// 有限模式只是清空数据，后面按需加载。需要先上加载锁
+func (c *GORMCache[K, M]) loadAll(db *gorm.DB) (err error) {
+	defer c.stats.observe(time.Now(), &c.stats.reloads, &err)
+	// 有限模式
+	if c.bounded() {
+		c.Lock()
+		c.clear()
+		c.OK = true
+		c.unlock()
+		return nil
+	}
+	// 查询
+	var ms []M
+	err = db.Find(&ms).Error
+	if err != nil {
+		c.loadError(err)
+		return err
 	}
+	c.replace(ms)
 	//
 	return nil
 }
 
+// replace 使用 ms 替换全部的数据，并标记 OK 为 true ，需要先上加载锁
+func (c *GORMCache[K, M]) replace(ms []M) {
+	// 在锁外创建新的数据
+	d := make(map[K]M, len(ms))
+	exp := make(map[K]int64)
+	t := time.Now().Add(c.TTL).UnixNano()
+	for _, m := range ms {
+		k := c.Key(m)
+		d[k] = m
+		if c.TTL > 0 {
+			exp[k] = t
+		}
+	}
+	// 替换
+	c.Lock()
+	for k := range d {
+		if v, ok := c.pending(k); ok {
+			d[k] = v
+		}
+	}
+	var zero M
+	for k, m := range c.D {
+		if _, ok := d[k]; !ok {
+			if c.OnEvict != nil {
+				c.events = append(c.events, gormCacheEvent[K, M]{k: k, m: m})
+			}
+			c.change(GORMCacheOpDelete, k, m, zero)
+		}
+	}
+	for k, m := range d {
+		if c.OnLoad != nil {
+			c.events = append(c.events, gormCacheEvent[K, M]{load: true, k: k, m: m})
+		}
+		if c.subN.Load() > 0 {
+			if old, ok := c.D[k]; !ok {
+				c.change(GORMCacheOpAdd, k, zero, m)
+			} else if !reflect.DeepEqual(old, m) {
+				c.change(GORMCacheOpUpdate, k, old, m)
+			}
+		}
+	}
+	c.D = d
+	c.exp = exp
+	c.indexReset()
+	c.OK = true
+	c.unlock()
+}
+
 // LoadMultiple 加载多个并返回，db 在外面初始化好
 func (c *GORMCache[K, M]) LoadMultiple(db *gorm.DB) (ms []M, err error) {
 	// 上锁
-	c.Lock()
+	c.lockLoad()
 	// 查询
 	err = db.Find(&ms).Error
 	if err == nil {
 		// 加载或替换
+		c.Lock()
 		for _, m := range ms {
-			c.D[c.Key(m)] = m
+			c.set(c.Key(m), m)
 		}
+		c.unlock()
 	}
 	// 解锁
-	c.Unlock()
+	c.unlockLoad()
 	//
 	return
 }
@@ -136,25 +703,21 @@ func (c *GORMCache[K, M]) LoadWhereWithContext(ctx context.Context, whereFunc fu
 	// 启用
 	if c.Cache {
 		// 上锁
-		c.Lock()
+		c.lockLoad()
 		//
-		if c.OK {
+		if c.isOK() || c.bounded() {
 			// 原数据有效，根据条件加载
-			err = c.loadMultiple(whereFunc(c.ModelWithContext(ctx)))
+			err = c.loadMultiple(whereFunc(c.loadModel(ctx)))
 			if err != nil {
 				// 标记
-				c.OK = false
+				c.loadError(err)
 			}
 		} else {
 			// 原数据无效直接全部加载
-			err = c.loadMultiple(c.ModelWithContext(ctx))
-			if err == nil {
-				// 标记
-				c.OK = true
-			}
+			err = c.loadAll(c.loadModel(ctx))
 		}
 		// 解锁
-		c.Unlock()
+		c.unlockLoad()
 	}
 	//
 	return
@@ -170,13 +733,11 @@ func (c *GORMCache[K, M]) LoadAllWithContext(ctx context.Context) (err error) {
 	// 启用
 	if c.Cache {
 		// 上锁
-		c.Lock()
+		c.lockLoad()
 		// 加载
-		err = c.loadMultiple(c.ModelWithContext(ctx))
-		// 标记
-		c.OK = err == nil
+		err = c.loadAll(c.loadModel(ctx))
 		// 解锁
-		c.Unlock()
+		c.unlockLoad()
 	}
 	//
 	return
@@ -192,23 +753,22 @@ func (c *GORMCache[K, M]) CheckWithContext(ctx context.Context) (err error) {
 	// 启用
 	if c.Cache {
 		// 上锁
-		c.Lock()
+		c.lockLoad()
 		// 加载
-		err = c.check(c.ModelWithContext(ctx))
+		err = c.check(c.loadModel(ctx))
 		// 解锁
-		c.Unlock()
+		c.unlockLoad()
 	}
 	//
 	return
 }
 
-// Check 检查内存数据是否需要重新加载，同步
+// check 检查内存数据是否需要重新加载，需要先上加载锁
+// 并发的调用在加载锁上等待，第一个加载成功后，后面的不再加载
 func (c *GORMCache[K, M]) check(db *gorm.DB) (err error) {
-	// 确保数据
-	if !c.OK {
-		err = c.loadMultiple(db)
-		// 标记
-		c.OK = err == nil
+	// 确保数据，有限模式不需要
+	if !c.isOK() && !c.bounded() {
+		err = c.loadAll(db)
 	}
 	return
 }
@@ -222,58 +782,67 @@ func (c *GORMCache[K, M]) Load(k K) error {
 func (c *GORMCache[K, M]) LoadWithContext(ctx context.Context, k K) (err error) {
 	// 启用
 	if c.Cache {
-		db := c.ModelWithContext(ctx)
+		db := c.loadModel(ctx)
 		// 上锁
-		c.Lock()
-		if c.OK {
+		c.lockLoad()
+		if c.isOK() || c.bounded() {
 			// 原数据有效，加载单个
-			err = c.loadOne(db, k)
+			_, _, err = c.loadOne(db, k)
 			if err != nil {
 				// 标记
-				c.OK = false
+				c.loadError(err)
 			}
 		} else {
 			// 原数据无效直接全部加载
-			err = c.loadMultiple(db)
-			if err == nil {
-				// 标记
-				c.OK = true
-			}
+			err = c.loadAll(db)
 		}
 		// 解锁
-		c.Unlock()
+		c.unlockLoad()
+	} else {
+		// 不启用，清除不存在的记录
+		c.forgetNotFound(k)
 	}
 	//
 	return
 }
 
-// All 返回所有，不要修改返回的指针，同步
+// forgetNotFound 清除不存在的记录
+func (c *GORMCache[K, M]) forgetNotFound(ks ...K) {
+	if c.NotFoundTTL <= 0 {
+		return
+	}
+	c.Lock()
+	for _, k := range ks {
+		delete(c.nf, k)
+	}
+	c.unlock()
+}
+
+// All 返回所有，没有设置 Clone 时不要修改返回的指针，同步
 func (c *GORMCache[K, M]) All() ([]M, error) {
 	return c.AllWithContext(context.Background())
 }
 
-// AllWithContext 返回所有，不要修改返回的指针，同步
+// AllWithContext 返回所有，没有设置 Clone 时不要修改返回的指针，同步
 func (c *GORMCache[K, M]) AllWithContext(ctx context.Context) (ms []M, err error) {
-	db := c.ModelWithContext(ctx)
-	// 不启用
-	if !c.Cache {
-		err = db.Find(&ms).Error
+	// 不启用，或者有限模式
+	if !c.Cache || c.bounded() {
+		err = c.loadModel(ctx).Find(&ms).Error
 		if err != nil {
 			return nil, err
 		}
 		return
 	}
-	// 上锁
-	c.Lock()
 	// 确保数据
-	err = c.check(c.ModelWithContext(ctx))
-	if err == nil {
-		for _, v := range c.D {
-			ms = append(ms, v)
-		}
+	err = c.rlock(ctx)
+	if err != nil {
+		return
+	}
+	for _, v := range c.D {
+		ms = append(ms, c.clone(v))
 	}
 	// 解锁
-	c.Unlock()
+	c.RUnlock()
 	//
 	return
 }
@@ -285,6 +854,16 @@ func (c *GORMCache[K, M]) List(page *GORMListPage, query GORMQuery, res *GORMLis
 
 // ListWithContext 返回列表，同步
 func (c *GORMCache[K, M]) ListWithContext(ctx context.Context, page *GORMListPage, query GORMQuery, res *GORMListData[M]) error {
+	// 内存
+	if c.MemoryList {
+		if c.Cache && !c.bounded() {
+			ok, err := c.listMemory(ctx, page, query, res)
+			if ok {
+				return err
+			}
+		}
+		return gormList(c.loadModel(ctx), page, query, res, c.listOrder())
+	}
 	// 没有条件
 	if IsNilOrEmpty(query) && IsNilOrEmpty(page) {
 		data, err := c.AllWithContext(ctx)
@@ -297,43 +876,96 @@ func (c *GORMCache[K, M]) ListWithContext(ctx context.Context, page *GORMListPag
 		return nil
 	}
 	// 数据库
-	return GORMList(c.ModelWithContext(ctx), page, query, res)
+	return GORMList(c.loadModel(ctx), page, query, res)
 }
 
-// Get 返回指定，不要修改返回的指针，同步
+// Get 返回指定，没有设置 Clone 时不要修改返回的指针，同步
 func (c *GORMCache[K, M]) Get(k K) (m M, err error) {
 	return c.GetWithContext(context.Background(), k)
 }
 
-// GetWithContext 返回指定，不要修改返回的指针，同步
+// GetWithContext 返回指定，不存在返回零值，没有设置 Clone 时不要修改返回的指针，同步
 func (c *GORMCache[K, M]) GetWithContext(ctx context.Context, k K) (m M, err error) {
+	m, err = c.FirstWithContext(ctx, k)
+	if err == ErrGORMCacheNotFound {
+		err = nil
+	}
+	return
+}
+
+// First 返回指定，不存在返回 ErrGORMCacheNotFound ，没有设置 Clone 时不要修改返回的指针，同步
+func (c *GORMCache[K, M]) First(k K) (m M, err error) {
+	return c.FirstWithContext(context.Background(), k)
+}
+
+// FirstWithContext 返回指定，不存在返回 ErrGORMCacheNotFound ，没有设置 Clone 时不要修改返回的指针，同步
+func (c *GORMCache[K, M]) FirstWithContext(ctx context.Context, k K) (m M, err error) {
 	// 不启用
 	if !c.Cache {
-		mm := c.New()
-		err = c.WhereKey(c.ModelWithContext(ctx), k).First(mm).Error
-		if err != nil {
-			if err == gorm.ErrRecordNotFound {
-				err = nil
-			}
-			return
+		c.stats.misses.Add(1)
+		m, err = c.firstDB(ctx, k)
+		if err == nil {
+			m = c.clone(m)
 		}
-		m = mm
-		//
 		return
 	}
-	// 上锁
-	c.Lock()
 	// 确保数据
-	err = c.check(c.ModelWithContext(ctx))
-	if err == nil {
-		m = c.D[k]
+	if c.bounded() {
+		c.RLock()
+	} else {
+		err = c.rlock(ctx)
+		if err != nil {
+			return
+		}
+	}
+	m, ok := c.D[k]
+	expired := ok && c.expired(k)
+	nf := !ok && c.isNotFound(k)
+	if ok && !expired {
+		c.stats.hits.Add(1)
+	} else {
+		c.stats.misses.Add(1)
+	}
+	if ok && c.evictor != nil {
+		c.evictor.touch(k)
 	}
 	// 解锁
-	c.Unlock()
+	c.RUnlock()
+	// 过期，或者有限模式没有命中，从数据库加载
+	if expired || (!ok && !nf && c.bounded()) {
+		m, err = c.loadKey(ctx, k)
+		if err == nil {
+			m = c.clone(m)
+		}
+		return
+	}
+	if !ok {
+		err = ErrGORMCacheNotFound
+		return
+	}
+	m = c.clone(m)
 	//
 	return
 }
 
+// dbEach 分批查询数据库，fn 返回 false 停止，
+// 用于有限模式下需要全部数据的查询
+func (c *GORMCache[K, M]) dbEach(ctx context.Context, fn func(M) bool) error {
+	var ms []M
+	err := c.loadModel(ctx).FindInBatches(&ms, GORMCacheBatchSize, func(tx *gorm.DB, batch int) error {
+		for _, m := range ms {
+			if !fn(m) {
+				return errGORMCacheStop
+			}
+		}
+		return nil
+	}).Error
+	if err == errGORMCacheStop {
+		err = nil
+	}
+	return err
+}
+
 // Add 添加，同步
 func (c *GORMCache[K, M]) Add(m M) (int64, error) {
 	return c.AddWithContext(context.Background(), m)
@@ -346,7 +978,7 @@ func (c *GORMCache[K, M]) AddWithContext(ctx context.Context, m M) (int64, error
 	if db.Error == nil {
 		// 内存
 		if db.RowsAffected > 0 {
-			c.LoadWithContext(ctx, c.Key(m))
+			c.changed(ctx, []K{c.Key(m)})
 		}
 	}
 	//
@@ -362,12 +994,16 @@ func (c *GORMCache[K, M]) Update(m M) (int64, error) {
 func (c *GORMCache[K, M]) UpdateWithContext(ctx context.Context, m M) (int64, error) {
 	// 数据库
 	k := c.Key(m)
-	db := c.WhereKey(c.ModelWithContext(ctx), k).Updates(m)
+	// 使用 m 作为模型，否则 gorm 会把更新的字段写到 c.M
+	db, _ := c.write(c.WhereKey(c.scoped(c.DB.WithContext(ctx).Model(m)), k), m, false)
 	if db.Error == nil {
 		// 内存
 		if db.RowsAffected > 0 {
-			c.LoadWithContext(ctx, k)
+			c.changed(ctx, []K{k})
 		}
+	} else if errors.Is(db.Error, ErrGORMConflict) {
+		// 内存的可能是旧的
+		c.changed(ctx, []K{k})
 	}
 	//
 	return db.RowsAffected, db.Error
@@ -380,13 +1016,45 @@ func (c *GORMCache[K, M]) BatchUpdate(ms []M) (int64, error) {
 
 // BatchUpdateWithContext 事务更新，同步
 func (c *GORMCache[K, M]) BatchUpdateWithContext(ctx context.Context, ms []M) (int64, error) {
-	var ks []K
 	// 数据库
-	err := c.ModelWithContext(ctx).Transaction(func(tx *gorm.DB) error {
+	ks, err := c.batchWrite(ctx, ms, false)
+	if err != nil {
+		// 内存的可能是旧的
+		if errors.Is(err, ErrGORMConflict) {
+			c.changed(ctx, ks)
+		}
+		return 0, err
+	}
+	// 内存
+	c.changed(ctx, ks)
+	//
+	return int64(len(ms)), nil
+}
+
+// write 更新或者保存 m ，设置了 Version 使用乐观锁，返回的函数用于事务回滚后还原版本
+func (c *GORMCache[K, M]) write(db *gorm.DB, m M, save bool) (*gorm.DB, func()) {
+	if c.Version != "" {
+		return gormVersionUpdate(db, m, c.Version, save)
+	}
+	if save {
+		return db.Save(m), func() {}
+	}
+	return db.Updates(m), func() {}
+}
+
+// batchWrite 在事务中逐个更新或者保存，返回主键，
+// 乐观锁冲突时返回冲突的主键，需要调用者重新加载
+func (c *GORMCache[K, M]) batchWrite(ctx context.Context, ms []M, save bool) (ks []K, err error) {
+	var undo []func()
+	err = c.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
 		for _, m := range ms {
 			k := c.Key(m)
-			db := c.WhereKey(tx, k).Updates(m)
+			db, fn := c.write(c.WhereKey(c.scoped(tx.Model(m)), k), m, save)
+			undo = append(undo, fn)
 			if db.Error != nil {
+				if errors.Is(db.Error, ErrGORMConflict) {
+					ks = []K{k}
+				}
 				return db.Error
 			}
 			ks = append(ks, k)
@@ -394,14 +1062,16 @@ func (c *GORMCache[K, M]) BatchUpdateWithContext(ctx context.Context, ms []M) (i
 		return nil
 	})
 	if err != nil {
-		return 0, err
+		// 回滚了，还原版本
+		for _, fn := range undo {
+			fn()
+		}
+		if errors.Is(err, ErrGORMConflict) {
+			return ks, err
+		}
+		return nil, err
 	}
-	// 内存
-	c.LoadWhereWithContext(ctx, func(db *gorm.DB) *gorm.DB {
-		return c.WhereKeys(db, ks)
-	})
-	//
-	return int64(len(ms)), nil
+	return
 }
 
 // UpdateCache 更新内存，同步。回调有可能为 nil
@@ -409,9 +1079,23 @@ func (c *GORMCache[K, M]) UpdateCache(k K, fn func(M)) {
 	// 上锁
 	c.Lock()
 	// 更新
-	fn(c.D[k])
+	old, ok := c.D[k]
+	m := old
+	if ok && c.Clone != nil {
+		// 修改副本后替换，不影响已经返回的数据
+		m = c.Clone(m)
+		fn(m)
+		c.D[k] = m
+	} else {
+		fn(m)
+	}
+	// 索引值可能修改了
+	if ok {
+		c.indexSet(k, m)
+		c.change(GORMCacheOpUpdate, k, old, m)
+	}
 	// 解锁
-	c.Unlock()
+	c.unlock()
 }
 
 // Save 保存，同步
@@ -423,12 +1107,15 @@ func (c *GORMCache[K, M]) Save(m M) (int64, error) {
 func (c *GORMCache[K, M]) SaveWithContext(ctx context.Context, m M) (int64, error) {
 	// 数据库
 	k := c.Key(m)
-	db := c.WhereKey(c.ModelWithContext(ctx), k).Save(m)
+	db, _ := c.write(c.WhereKey(c.scoped(c.DB.WithContext(ctx).Model(m)), k), m, true)
 	if db.Error == nil {
 		// 内存
 		if db.RowsAffected > 0 {
-			c.LoadWithContext(ctx, k)
+			c.changed(ctx, []K{k})
 		}
+	} else if errors.Is(db.Error, ErrGORMConflict) {
+		// 内存的可能是旧的
+		c.changed(ctx, []K{k})
 	}
 	//
 	return db.RowsAffected, db.Error
@@ -441,26 +1128,17 @@ func (c *GORMCache[K, M]) BatchSave(ms []M) (int64, error) {
 
 // BatchSaveWithContext 事务保存，同步
 func (c *GORMCache[K, M]) BatchSaveWithContext(ctx context.Context, ms []M) (int64, error) {
-	var ks []K
 	// 数据库
-	err := c.ModelWithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		for _, m := range ms {
-			k := c.Key(m)
-			db := c.WhereKey(tx, k).Save(m)
-			if db.Error != nil {
-				return db.Error
-			}
-			ks = append(ks, k)
-		}
-		return nil
-	})
+	ks, err := c.batchWrite(ctx, ms, true)
 	if err != nil {
+		// 内存的可能是旧的
+		if errors.Is(err, ErrGORMConflict) {
+			c.changed(ctx, ks)
+		}
 		return 0, err
 	}
 	// 内存
-	c.LoadWhereWithContext(ctx, func(db *gorm.DB) *gorm.DB {
-		return c.WhereKeys(db, ks)
-	})
+	c.changed(ctx, ks)
 	//
 	return int64(len(ms)), nil
 }
@@ -474,11 +1152,9 @@ func (c *GORMCache[K, M]) Delete(k K) (int64, error) {
 func (c *GORMCache[K, M]) DeleteWithContext(ctx context.Context, k K) (int64, error) {
 	// 数据库
 	db := c.WhereKey(c.ModelWithContext(ctx), k).Delete(c.M)
-	if db.Error == nil {
+	if db.Error == nil && db.RowsAffected > 0 {
 		// 内存
-		if db.RowsAffected > 0 && c.Cache {
-			c.DeleteCache(k)
-		}
+		c.deleted([]K{k})
 	}
 	//
 	return db.RowsAffected, db.Error
@@ -493,26 +1169,49 @@ func (c *GORMCache[K, M]) BatchDelete(ks []K) (int64, error) {
 func (c *GORMCache[K, M]) BatchDeleteWithContext(ctx context.Context, ks []K) (int64, error) {
 	// 数据库
 	db := c.WhereKeys(c.ModelWithContext(ctx), ks).Delete(c.M)
-	if db.Error == nil {
+	if db.Error == nil && db.RowsAffected > 0 {
 		// 内存
-		if db.RowsAffected > 0 && c.Cache {
-			c.BatchDeleteCache(ks)
-		}
+		c.deleted(ks)
 	}
 	//
 	return db.RowsAffected, db.Error
 }
 
+// changed 数据库添加或者修改了 ks 之后，重新加载内存并发布事件
+func (c *GORMCache[K, M]) changed(ctx context.Context, ks []K) {
+	if len(ks) < 1 {
+		return
+	}
+	if len(ks) == 1 {
+		c.LoadWithContext(ctx, ks[0])
+	} else {
+		c.forgetNotFound(ks...)
+		c.LoadWhereWithContext(ctx, func(db *gorm.DB) *gorm.DB {
+			return c.WhereKeys(db, ks)
+		})
+	}
+	c.publish(GORMCacheBusChange, ks)
+}
+
+// deleted 数据库删除了 ks 之后，删除内存并发布事件
+func (c *GORMCache[K, M]) deleted(ks []K) {
+	c.forgetDirty(ks)
+	if c.Cache {
+		c.BatchDeleteCache(ks)
+	}
+	c.publish(GORMCacheBusDelete, ks)
+}
+
 // BatchDeleteCache 删除内存，同步
 func (c *GORMCache[K, M]) BatchDeleteCache(ks []K) {
 	// 上锁
 	c.Lock()
 	// 删除
 	for _, k := range ks {
-		delete(c.D, k)
+		c.del(k)
 	}
 	// 解锁
-	c.Unlock()
+	c.unlock()
 }
 
 // DeleteCache 删除内存
@@ -520,38 +1219,81 @@ func (c *GORMCache[K, M]) DeleteCache(k K) {
 	// 上锁
 	c.Lock()
 	// 删除
-	delete(c.D, k)
+	c.del(k)
 	// 解锁
-	c.Unlock()
+	c.unlock()
 }
 
-// DeleteCache 删除内存
+// DeleteCacheWhere 删除内存中第一个匹配的
 func (c *GORMCache[K, M]) DeleteCacheWhere(match func(m M) bool) {
 	// 上锁
 	c.Lock()
 	// 删除
 	for k, m := range c.D {
 		if match(m) {
-			delete(c.D, k)
-			return
+			c.del(k)
+			break
 		}
 	}
 	// 解锁
-	c.Unlock()
+	c.unlock()
 }
 
 // BatchDeleteCacheWhere 批量删除内存
 func (c *GORMCache[K, M]) BatchDeleteCacheWhere(match func(m M) bool) {
+	c.evictWhere(GORMCacheOpDelete, func(k K, m M) bool {
+		return match(m)
+	})
+}
+
+// EvictCacheWhere 删除内存中所有匹配的，返回删除的数据
+func (c *GORMCache[K, M]) EvictCacheWhere(match func(m M) bool) map[K]M {
+	return c.evictWhere(GORMCacheOpEvict, func(k K, m M) bool {
+		return match(m)
+	})
+}
+
+// evictWhere 删除内存中所有匹配的，返回删除的数据，op 是通知订阅的类型
+func (c *GORMCache[K, M]) evictWhere(op GORMCacheOp, match func(K, M) bool) map[K]M {
+	d := make(map[K]M)
 	// 上锁
 	c.Lock()
 	// 删除
 	for k, m := range c.D {
-		if match(m) {
-			delete(c.D, k)
+		if match(k, m) {
+			d[k] = m
+			c.remove(k, op)
 		}
 	}
 	// 解锁
-	c.Unlock()
+	c.unlock()
+	//
+	return d
+}
+
+// InvalidateAll 清空内存并标记 OK 为 false ，下一次读取的时候重新加载
+func (c *GORMCache[K, M]) InvalidateAll() {
+	// 上锁
+	c.Lock()
+	// 清空
+	c.clear()
+	c.OK = false
+	// 解锁
+	c.unlock()
+}
+
+// GORMEvictCacheRange 删除内存中 [min,max) 的 key ，返回删除的数据
+func GORMEvictCacheRange[K Ordered, M any](c *GORMCache[K, M], min, max K) map[K]M {
+	return c.evictWhere(GORMCacheOpEvict, func(k K, m M) bool {
+		return k >= min && k < max
+	})
+}
+
+// GORMEvictCachePrefix 删除内存中前缀是 prefix 的 key ，返回删除的数据
+func GORMEvictCachePrefix[K ~string, M any](c *GORMCache[K, M], prefix string) map[K]M {
+	return c.evictWhere(GORMCacheOpEvict, func(k K, m M) bool {
+		return strings.HasPrefix(string(k), prefix)
+	})
 }
 
 // ForeachCache 遍历缓存，同步
@@ -561,18 +1303,23 @@ func (c *GORMCache[K, M]) ForeachCache(fc func(M)) (err error) {
 
 // ForeachCacheWithContext 遍历缓存，同步
 func (c *GORMCache[K, M]) ForeachCacheWithContext(ctx context.Context, cb func(M)) (err error) {
-	// 上锁
-	c.Lock()
+	// 有限模式
+	if c.bounded() {
+		return c.dbEach(ctx, func(m M) bool {
+			cb(m)
+			return true
+		})
+	}
 	// 确保数据
-	err = c.check(c.ModelWithContext(ctx))
+	err = c.rlock(ctx)
 	if err == nil {
 		// 循环
 		for _, m := range c.D {
-			cb(m)
+			cb(c.clone(m))
 		}
+		// 解锁
+		c.RUnlock()
 	}
-	// 解锁
-	c.Unlock()
 	//
 	return
 }
@@ -584,20 +1331,28 @@ func (c *GORMCache[K, M]) SearchCache(match func(M) bool) ([]M, error) {
 
 // SearchCacheWithContext 在内存中查找所有，同步
 func (c *GORMCache[K, M]) SearchCacheWithContext(ctx context.Context, match func(M) bool) (mm []M, err error) {
-	// 上锁
-	c.Lock()
+	// 有限模式
+	if c.bounded() {
+		err = c.dbEach(ctx, func(m M) bool {
+			if match(m) {
+				mm = append(mm, m)
+			}
+			return true
+		})
+		return
+	}
 	// 确保数据
-	err = c.check(c.ModelWithContext(ctx))
+	err = c.rlock(ctx)
 	if err == nil {
 		// 查找
 		for _, m := range c.D {
 			if match(m) {
-				mm = append(mm, m)
+				mm = append(mm, c.clone(m))
 			}
 		}
+		// 解锁
+		c.RUnlock()
 	}
-	// 解锁
-	c.Unlock()
 	//
 	return
 }
@@ -609,21 +1364,68 @@ func (c *GORMCache[K, M]) SearchCacheIn(ks []K) ([]M, error) {
 
 // SearchCacheInWithContext 在内存中查找 key ，同步
 func (c *GORMCache[K, M]) SearchCacheInWithContext(ctx context.Context, ks []K) (mm []M, err error) {
-	// 上锁
-	c.Lock()
+	// 有限模式
+	if c.bounded() {
+		return c.searchBoundedIn(ctx, ks)
+	}
 	// 确保数据
-	err = c.check(c.ModelWithContext(ctx))
+	err = c.rlock(ctx)
 	if err == nil {
 		// 查找
 		for i := 0; i < len(ks); i++ {
 			m, ok := c.D[ks[i]]
 			if ok {
-				mm = append(mm, m)
+				mm = append(mm, c.clone(m))
 			}
 		}
+		// 解锁
+		c.RUnlock()
+	}
+	//
+	return
+}
+
+// searchBoundedIn 有限模式的 SearchCacheInWithContext ，
+// 先在内存中查找，没有的再到数据库加载
+func (c *GORMCache[K, M]) searchBoundedIn(ctx context.Context, ks []K) (mm []M, err error) {
+	d := make(map[K]M)
+	var miss []K
+	// 内存
+	c.RLock()
+	for _, k := range ks {
+		m, ok := c.D[k]
+		if ok && !c.expired(k) {
+			d[k] = m
+			if c.evictor != nil {
+				c.evictor.touch(k)
+			}
+			continue
+		}
+		miss = append(miss, k)
+	}
+	c.RUnlock()
+	// 数据库
+	if len(miss) > 0 {
+		var ms []M
+		err = c.WhereKeys(c.loadModel(ctx), miss).Find(&ms).Error
+		if err != nil {
+			return
+		}
+		c.Lock()
+		for _, m := range ms {
+			k := c.Key(m)
+			c.set(k, m)
+			d[k] = m
+		}
+		c.unlock()
+	}
+	// 按照 ks 的顺序
+	for _, k := range ks {
+		m, ok := d[k]
+		if ok {
+			mm = append(mm, c.clone(m))
+		}
 	}
-	// 解锁
-	c.Unlock()
 	//
 	return
 }
@@ -635,21 +1437,30 @@ func (c *GORMCache[K, M]) SearchCacheOne(match func(M) bool) (m M, err error) {
 
 // SearchCacheOneWithContext 在内存中查找第一个，同步
 func (c *GORMCache[K, M]) SearchCacheOneWithContext(ctx context.Context, match func(M) bool) (m M, err error) {
-	// 上锁
-	c.Lock()
+	// 有限模式
+	if c.bounded() {
+		err = c.dbEach(ctx, func(v M) bool {
+			if match(v) {
+				m = v
+				return false
+			}
+			return true
+		})
+		return
+	}
 	// 确保数据
-	err = c.check(c.ModelWithContext(ctx))
+	err = c.rlock(ctx)
 	if err == nil {
 		// 查找
 		for _, v := range c.D {
 			if match(v) {
-				m = v
+				m = c.clone(v)
 				break
 			}
 		}
+		// 解锁
+		c.RUnlock()
 	}
-	// 解锁
-	c.Unlock()
 	//
 	return
 }
@@ -661,10 +1472,18 @@ func (c *GORMCache[K, M]) CacheCount(match func(M) bool) (int64, error) {
 
 // CacheCountWithContext 返回内存匹配数量，同步
 func (c *GORMCache[K, M]) CacheCountWithContext(ctx context.Context, match func(M) bool) (n int64, err error) {
-	// 上锁
-	c.Lock()
+	// 有限模式
+	if c.bounded() {
+		err = c.dbEach(ctx, func(v M) bool {
+			if match(v) {
+				n++
+			}
+			return true
+		})
+		return
+	}
 	// 确保数据
-	err = c.check(c.ModelWithContext(ctx))
+	err = c.rlock(ctx)
 	if err == nil {
 		// 查找
 		for _, v := range c.D {
@@ -672,9 +1491,9 @@ func (c *GORMCache[K, M]) CacheCountWithContext(ctx context.Context, match func(
 				n++
 			}
 		}
+		// 解锁
+		c.RUnlock()
 	}
-	// 解锁
-	c.Unlock()
 	//
 	return
 }
@@ -686,15 +1505,18 @@ func (c *GORMCache[K, M]) CacheTotal() (int64, error) {
 
 // CacheTotalWithContext 返回内存总量，同步
 func (c *GORMCache[K, M]) CacheTotalWithContext(ctx context.Context) (n int64, err error) {
-	// 上锁
-	c.Lock()
+	// 有限模式
+	if c.bounded() {
+		err = c.ModelWithContext(ctx).Count(&n).Error
+		return
+	}
 	// 确保数据
-	err = c.check(c.ModelWithContext(ctx))
+	err = c.rlock(ctx)
 	if err == nil {
 		n = int64(len(c.D))
+		// 解锁
+		c.RUnlock()
 	}
-	// 解锁
-	c.Unlock()
 	//
 	return
 }
@@ -705,13 +1527,23 @@ func GORMSearchCache[T any, K comparable, M any](c *GORMCache[K, M], match func(
 }
 
 // GORMSearchCacheWithContext 模板化的 Search
-// 用于返回 match 的其他结构列表
+// 用于返回 match 的其他结构列表，match 的参数是内存中的数据，
+// 不会使用 Clone 拷贝，返回的 T 不要引用它
 func GORMSearchCacheWithContext[T any, K comparable, M any](ctx context.Context, c *GORMCache[K, M], match func(M) (bool, T)) ([]T, error) {
 	var vv []T
-	// 上锁
-	c.Lock()
+	// 有限模式
+	if c.bounded() {
+		err := c.dbEach(ctx, func(m M) bool {
+			o, v := match(m)
+			if o {
+				vv = append(vv, v)
+			}
+			return true
+		})
+		return vv, err
+	}
 	// 确保数据
-	err := c.check(c.ModelWithContext(ctx))
+	err := c.rlock(ctx)
 	if err == nil {
 		// 查找
 		for _, m := range c.D {
@@ -720,19 +1552,26 @@ func GORMSearchCacheWithContext[T any, K comparable, M any](ctx context.Context,
 				vv = append(vv, v)
 			}
 		}
+		// 解锁
+		c.RUnlock()
 	}
-	// 解锁
-	c.Unlock()
 	//
 	return vv, err
 }
 
+// gormIDColumn 是 WhereID 和 WhereIDs 的列，带上表名，可以和 Joins 一起使用
+var gormIDColumn = clause.Column{Table: clause.CurrentTable, Name: "ID"}
+
 // WhereID 初始化 GORMCache 需要的函数
 func WhereID[T any](db *gorm.DB, id T) *gorm.DB {
-	return db.Where("`ID` = ?", id)
+	return db.Where(clause.Eq{Column: gormIDColumn, Value: id})
 }
 
 // WhereIDs 初始化 GORMCache 需要的函数
 func WhereIDs[T any](db *gorm.DB, id []T) *gorm.DB {
-	return db.Where("`ID` IN ?", id)
+	vs := make([]any, len(id))
+	for i := range id {
+		vs[i] = id[i]
+	}
+	return db.Where(clause.IN{Column: gormIDColumn, Values: vs})
 }
@@ -0,0 +1,131 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// NewGORMCacheAuto 返回新的缓存，使用 db 的 NamingStrategy 解析 M 的 gorm schema ，
+// 根据主键生成 New ，Key ，WhereKey 和 WhereKeys ，需要不一样的可以在返回后替换。
+// M 必须是结构指针。
+// 单个主键时，K 必须是主键字段的类型。
+// 复合主键时，K 必须是结构，字段的名称和类型与 M 的主键字段一样，
+// 比如 M 的主键是 A int64 和 B string ，K 就是 struct { A int64; B string } 。
+func NewGORMCacheAuto[K comparable, M any](db *gorm.DB, cache bool) (*GORMCache[K, M], error) {
+	// M
+	mt := reflect.TypeOf((*M)(nil)).Elem()
+	if mt.Kind() != reflect.Pointer || mt.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("model %s must be struct pointer", mt)
+	}
+	newFunc := func() M {
+		return reflect.New(mt.Elem()).Interface().(M)
+	}
+	// 主键
+	stmt := &gorm.Statement{DB: db}
+	err := stmt.Parse(newFunc())
+	if err != nil {
+		return nil, err
+	}
+	pks := stmt.Schema.PrimaryFields
+	if len(pks) < 1 {
+		return nil, fmt.Errorf("model %s has no primary key", mt)
+	}
+	kt := reflect.TypeOf((*K)(nil)).Elem()
+	var keyFunc func(M) K
+	var whereKeyFunc func(*gorm.DB, K) *gorm.DB
+	var whereKeysFunc func(*gorm.DB, []K) *gorm.DB
+	if len(pks) == 1 {
+		keyFunc, whereKeyFunc, whereKeysFunc, err = gormCacheAutoKey[K, M](pks[0], kt)
+	} else {
+		keyFunc, whereKeyFunc, whereKeysFunc, err = gormCacheAutoStructKey[K, M](pks, kt)
+	}
+	if err != nil {
+		return nil, err
+	}
+	//
+	return NewGORMCache(db, cache, newFunc, keyFunc, whereKeyFunc, whereKeysFunc), nil
+}
+
+// gormCacheAutoKey 返回单个主键的函数
+func gormCacheAutoKey[K comparable, M any](f *schema.Field, kt reflect.Type) (
+	func(M) K,
+	func(*gorm.DB, K) *gorm.DB,
+	func(*gorm.DB, []K) *gorm.DB,
+	error,
+) {
+	if f.FieldType != kt {
+		return nil, nil, nil, fmt.Errorf("key %s must be %s", kt, f.FieldType)
+	}
+	ctx := context.Background()
+	column := clause.Column{Table: clause.CurrentTable, Name: f.DBName}
+	return func(m M) K {
+			return f.ReflectValueOf(ctx, reflect.ValueOf(m).Elem()).Interface().(K)
+		}, func(db *gorm.DB, k K) *gorm.DB {
+			return db.Where(clause.Eq{Column: column, Value: k})
+		}, func(db *gorm.DB, ks []K) *gorm.DB {
+			vs := make([]any, len(ks))
+			for i, k := range ks {
+				vs[i] = k
+			}
+			return db.Where(clause.IN{Column: column, Values: vs})
+		}, nil
+}
+
+// gormCacheAutoStructKey 返回复合主键的函数
+func gormCacheAutoStructKey[K comparable, M any](pks []*schema.Field, kt reflect.Type) (
+	func(M) K,
+	func(*gorm.DB, K) *gorm.DB,
+	func(*gorm.DB, []K) *gorm.DB,
+	error,
+) {
+	if kt.Kind() != reflect.Struct || kt.NumField() != len(pks) {
+		return nil, nil, nil, fmt.Errorf("key %s must be struct with %d fields", kt, len(pks))
+	}
+	// K 的字段对应的主键
+	fs := make([]*schema.Field, len(pks))
+	cs := make([]string, len(pks))
+	for i := 0; i < kt.NumField(); i++ {
+		kf := kt.Field(i)
+		for _, f := range pks {
+			if f.Name == kf.Name {
+				fs[i] = f
+				break
+			}
+		}
+		if !kf.IsExported() || fs[i] == nil || fs[i].FieldType != kf.Type {
+			return nil, nil, nil, fmt.Errorf("key %s field %s must be primary key", kt, kf.Name)
+		}
+		cs[i] = fs[i].DBName
+	}
+	ctx := context.Background()
+	values := func(k K) []any {
+		v := reflect.ValueOf(k)
+		vs := make([]any, len(fs))
+		for i := range fs {
+			vs[i] = v.Field(i).Interface()
+		}
+		return vs
+	}
+	return func(m M) K {
+			var k K
+			kv := reflect.ValueOf(&k).Elem()
+			mv := reflect.ValueOf(m).Elem()
+			for i, f := range fs {
+				kv.Field(i).Set(f.ReflectValueOf(ctx, mv))
+			}
+			return k
+		}, func(db *gorm.DB, k K) *gorm.DB {
+			return gormWhereKey(db, cs, values(k))
+		}, func(db *gorm.DB, ks []K) *gorm.DB {
+			vs := make([][]any, len(ks))
+			for i, k := range ks {
+				vs[i] = values(k)
+			}
+			return gormWhereKeys(db, cs, vs)
+		}, nil
+}
@@ -0,0 +1,220 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// gormCacheBehind 是延迟写的配置
+type gormCacheBehind[K comparable] struct {
+	// 修改的数量达到这个值立即写入
+	size int
+	// true 使用 Save ，否则使用 Updates
+	save bool
+	// 写入失败回调，返回 true 重试
+	onError func([]K, error) bool
+	// 通知协程立即写入
+	c chan struct{}
+}
+
+// WriteBehind 启动延迟写协程，每隔 interval 把 UpdateBehind 修改的数据批量写入数据库，
+// 修改的数量达到 size 时立即写入，size 小于等于 0 只按照间隔。
+// save 为 true 使用 Save 写入所有字段，否则使用 Updates ，零值字段不会写入。
+// 写入失败回调 onError ，返回 true 下次重试，返回 false 丢弃这些修改并从数据库重新加载，
+// onError 为 nil 一直重试。乐观锁冲突的修改是基于旧的数据，不会重试，
+// 丢弃并从数据库重新加载，也会回调 onError ，其他的修改按照 onError 的返回值。
+// ctx 结束或者调用 Close 后协程退出，Close 会写入剩下的数据。应该在初始化的时候调用
+func (c *GORMCache[K, M]) WriteBehind(ctx context.Context, interval time.Duration, size int, save bool, onError func([]K, error) bool) {
+	c.behind.size = size
+	c.behind.save = save
+	c.behind.onError = onError
+	c.behind.c = make(chan struct{}, 1)
+	c.wait.Add(1)
+	go c.behindRoutine(ctx, interval)
+}
+
+// behindRoutine 在协程中定时写入
+func (c *GORMCache[K, M]) behindRoutine(ctx context.Context, interval time.Duration) {
+	defer c.wait.Done()
+	//
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.quit.C:
+			return
+		case <-ticker.C:
+			c.FlushWithContext(ctx)
+		case <-c.behind.c:
+			c.FlushWithContext(ctx)
+		}
+	}
+}
+
+// UpdateBehind 修改内存，并记录下来，由 WriteBehind 的协程或者 Flush 写入数据库。
+// 多次修改相同的 key 只写入最后一次。
+// fn 修改的是副本，修改后替换，使用 Clone 拷贝，没有设置使用 GORMCacheDeepCopy 。
+// 需要开启缓存，不存在返回 ErrGORMCacheNotFound ，同步
+func (c *GORMCache[K, M]) UpdateBehind(k K, fn func(M)) error {
+	return c.UpdateBehindWithContext(context.Background(), k, fn)
+}
+
+// UpdateBehindWithContext 修改内存，并记录下来，由 WriteBehind 的协程或者 Flush 写入数据库。
+// 多次修改相同的 key 只写入最后一次。
+// fn 修改的是副本，修改后替换，使用 Clone 拷贝，没有设置使用 GORMCacheDeepCopy 。
+// 需要开启缓存，不存在返回 ErrGORMCacheNotFound ，同步
+func (c *GORMCache[K, M]) UpdateBehindWithContext(ctx context.Context, k K, fn func(M)) error {
+	// 确保数据，有限模式会加载单个
+	_, err := c.FirstWithContext(ctx, k)
+	if err != nil {
+		return err
+	}
+	clone := c.Clone
+	if clone == nil {
+		clone = GORMCacheDeepCopy(c.New)
+	}
+	// 上锁
+	c.Lock()
+	m, ok := c.D[k]
+	if !ok {
+		// 刚好被淘汰或者删除
+		c.unlock()
+		return ErrGORMCacheNotFound
+	}
+	// 修改副本，正在写入的数据不会被修改
+	old := m
+	m = clone(m)
+	fn(m)
+	c.D[k] = m
+	c.indexSet(k, m)
+	c.change(GORMCacheOpUpdate, k, old, m)
+	if c.dirty == nil {
+		c.dirty = make(map[K]M)
+	}
+	c.dirty[k] = m
+	n := len(c.dirty)
+	// 解锁
+	c.unlock()
+	// 通知
+	if c.behind.size > 0 && n >= c.behind.size && c.behind.c != nil {
+		select {
+		case c.behind.c <- struct{}{}:
+		default:
+		}
+	}
+	//
+	return nil
+}
+
+// Flush 把 UpdateBehind 修改的数据写入数据库，同步
+func (c *GORMCache[K, M]) Flush() error {
+	return c.FlushWithContext(context.Background())
+}
+
+// FlushWithContext 把 UpdateBehind 修改的数据写入数据库，同步
+func (c *GORMCache[K, M]) FlushWithContext(ctx context.Context) error {
+	// 上锁
+	c.flushLock.Lock()
+	defer c.flushLock.Unlock()
+	// 取出
+	c.Lock()
+	d := c.dirty
+	c.dirty = nil
+	c.flushing = d
+	c.Unlock()
+	if len(d) < 1 {
+		return nil
+	}
+	// 写入副本，gorm 会修改 UpdatedAt 和版本，内存中的数据不能在锁外修改
+	clone := c.Clone
+	if clone == nil {
+		clone = GORMCacheDeepCopy(c.New)
+	}
+	ks := make([]K, 0, len(d))
+	ms := make([]M, 0, len(d))
+	for k, m := range d {
+		ks = append(ks, k)
+		ms = append(ms, clone(m))
+	}
+	// 数据库
+	conflict, err := c.batchWrite(ctx, ms, c.behind.save)
+	if !errors.Is(err, ErrGORMConflict) {
+		conflict = nil
+	}
+	retry := err != nil && (c.behind.onError == nil || c.behind.onError(ks, err))
+	// 失败重试，没有被再次修改的放回去
+	c.Lock()
+	c.flushing = nil
+	// 冲突的丢弃，期间再次修改的也是基于旧的数据
+	for _, k := range conflict {
+		delete(d, k)
+		delete(c.dirty, k)
+	}
+	if err == nil {
+		// 使用写入后的数据，期间没有被再次修改，删除或者淘汰的
+		for i, k := range ks {
+			if m, ok := c.D[k]; ok && gormCacheSame(m, d[k]) {
+				if _, ok = c.dirty[k]; !ok {
+					c.set(k, ms[i])
+				}
+			}
+		}
+	}
+	if retry {
+		if c.dirty == nil {
+			c.dirty = make(map[K]M)
+		}
+		for k, m := range d {
+			if _, ok := c.dirty[k]; !ok {
+				c.dirty[k] = m
+			}
+		}
+	}
+	c.unlock()
+	if err == nil {
+		c.publish(GORMCacheBusChange, ks)
+		return nil
+	}
+	// 丢弃，内存使用数据库的数据
+	if !retry {
+		c.LoadWhereWithContext(ctx, func(db *gorm.DB) *gorm.DB {
+			return c.WhereKeys(db, ks)
+		})
+	} else {
+		c.changed(ctx, conflict)
+	}
+	return err
+}
+
+// gormCacheSame 返回 a 和 b 是否同一个数据，指针比较地址，其他的比较值
+func gormCacheSame[M any](a, b M) bool {
+	va := reflect.ValueOf(&a).Elem()
+	if va.Kind() == reflect.Pointer {
+		return va.Pointer() == reflect.ValueOf(&b).Elem().Pointer()
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// pending 返回还没有写入数据库的修改，需要先上锁
+func (c *GORMCache[K, M]) pending(k K) (M, bool) {
+	m, ok := c.dirty[k]
+	if !ok {
+		m, ok = c.flushing[k]
+	}
+	return m, ok
+}
+
+// forgetDirty 删除还没有写入数据库的修改，用于数据库已经删除
+func (c *GORMCache[K, M]) forgetDirty(ks []K) {
+	c.Lock()
+	for _, k := range ks {
+		delete(c.dirty, k)
+	}
+	c.Unlock()
+}
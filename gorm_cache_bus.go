@@ -0,0 +1,298 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GORMCacheBusOp 是 GORMCacheBusEvent 的类型
+type GORMCacheBusOp int
+
+const (
+	// GORMCacheBusChange 数据有修改，重新加载 Keys
+	GORMCacheBusChange GORMCacheBusOp = iota
+	// GORMCacheBusDelete 数据已删除，删除内存中的 Keys
+	GORMCacheBusDelete
+	// GORMCacheBusReload 全部重新加载
+	GORMCacheBusReload
+)
+
+// GORMCacheBusEvent 是多个进程之间同步缓存的事件
+type GORMCacheBusEvent struct {
+	// 发送的节点，用于忽略自己发送的
+	Node string `json:"node"`
+	// 缓存的名称，一般是表名
+	Name string `json:"name"`
+	// 类型
+	Op GORMCacheBusOp `json:"op"`
+	// json 格式的 []K
+	Keys json.RawMessage `json:"keys,omitempty"`
+}
+
+// GORMCacheBus 是多个进程之间同步缓存的消息总线
+type GORMCacheBus interface {
+	// 发布事件
+	Publish(*GORMCacheBusEvent) error
+	// 订阅事件，返回取消订阅的函数
+	Subscribe(func(*GORMCacheBusEvent)) func()
+}
+
+var (
+	// gormCacheNode 用于生成节点
+	gormCacheNode int64
+	// gormCacheNodePrefix 是节点的前缀
+	gormCacheNodePrefix = func() string {
+		host, _ := os.Hostname()
+		return fmt.Sprintf("%s-%d-%d", host, os.Getpid(), time.Now().UnixNano())
+	}()
+)
+
+// newGORMCacheNode 返回唯一的节点
+func newGORMCacheNode() string {
+	return fmt.Sprintf("%s-%d", gormCacheNodePrefix, atomic.AddInt64(&gormCacheNode, 1))
+}
+
+// SetBus 设置消息总线并订阅，name 是缓存的名称，相同名称的缓存之间同步。
+// 添加，修改，保存，删除之后发布事件，收到其他节点的事件后重新加载或者删除。
+// K 需要能被 json 格式化
+func (c *GORMCache[K, M]) SetBus(name string, bus GORMCacheBus) {
+	c.busName = name
+	c.bus = bus
+	c.busNode = newGORMCacheNode()
+	c.busCancel = bus.Subscribe(c.onBusEvent)
+}
+
+// publish 发布事件，错误忽略，和加载内存的错误一样
+func (c *GORMCache[K, M]) publish(op GORMCacheBusOp, ks []K) {
+	if c.bus == nil {
+		return
+	}
+	e := &GORMCacheBusEvent{
+		Node: c.busNode,
+		Name: c.busName,
+		Op:   op,
+	}
+	if len(ks) > 0 {
+		d, err := json.Marshal(ks)
+		if err != nil {
+			return
+		}
+		e.Keys = d
+	}
+	c.bus.Publish(e)
+}
+
+// PublishReload 发布全部重新加载的事件，用于直接使用 sql 修改了数据
+func (c *GORMCache[K, M]) PublishReload() {
+	c.publish(GORMCacheBusReload, nil)
+}
+
+// onBusEvent 处理其他节点的事件
+func (c *GORMCache[K, M]) onBusEvent(e *GORMCacheBusEvent) {
+	if e.Node == c.busNode || e.Name != c.busName {
+		return
+	}
+	ctx := context.Background()
+	// 全部
+	if e.Op == GORMCacheBusReload {
+		c.LoadAllWithContext(ctx)
+		return
+	}
+	// 解析
+	var ks []K
+	if err := json.Unmarshal(e.Keys, &ks); err != nil || len(ks) < 1 {
+		return
+	}
+	switch e.Op {
+	case GORMCacheBusChange:
+		if len(ks) == 1 {
+			c.LoadWithContext(ctx, ks[0])
+			return
+		}
+		c.LoadWhereWithContext(ctx, func(db *gorm.DB) *gorm.DB {
+			return c.WhereKeys(db, ks)
+		})
+	case GORMCacheBusDelete:
+		c.BatchDeleteCache(ks)
+	}
+}
+
+// gormCacheBusSubs 保存订阅，用于实现 GORMCacheBus
+type gormCacheBusSubs struct {
+	l  sync.RWMutex
+	id int
+	d  map[int]func(*GORMCacheBusEvent)
+}
+
+// Subscribe 实现 GORMCacheBus
+func (s *gormCacheBusSubs) Subscribe(fn func(*GORMCacheBusEvent)) func() {
+	s.l.Lock()
+	if s.d == nil {
+		s.d = make(map[int]func(*GORMCacheBusEvent))
+	}
+	s.id++
+	id := s.id
+	s.d[id] = fn
+	s.l.Unlock()
+	return func() {
+		s.l.Lock()
+		delete(s.d, id)
+		s.l.Unlock()
+	}
+}
+
+// dispatch 回调所有的订阅
+func (s *gormCacheBusSubs) dispatch(e *GORMCacheBusEvent) {
+	s.l.RLock()
+	fs := make([]func(*GORMCacheBusEvent), 0, len(s.d))
+	for _, f := range s.d {
+		fs = append(fs, f)
+	}
+	s.l.RUnlock()
+	for _, f := range fs {
+		f(e)
+	}
+}
+
+// GORMCacheMemoryBus 是进程内的 GORMCacheBus ，
+// 在 Publish 中同步回调，用于测试或者同一个进程的多个缓存
+type GORMCacheMemoryBus struct {
+	gormCacheBusSubs
+}
+
+// NewGORMCacheMemoryBus 返回新的 GORMCacheMemoryBus
+func NewGORMCacheMemoryBus() *GORMCacheMemoryBus {
+	return new(GORMCacheMemoryBus)
+}
+
+// Publish 实现 GORMCacheBus
+func (b *GORMCacheMemoryBus) Publish(e *GORMCacheBusEvent) error {
+	b.dispatch(e)
+	return nil
+}
+
+// GORMCacheBusEventModel 是 GORMCacheDBBus 的数据表
+type GORMCacheBusEventModel struct {
+	// 自增，用于轮询
+	ID int64 `gorm:"column:ID;primaryKey;autoIncrement"`
+	// 创建时间戳，用于清理
+	CreatedAt int64 `gorm:"column:CreatedAt;index"`
+	// 发送的节点
+	Node string `gorm:"column:Node;type:varchar(128)"`
+	// 缓存的名称
+	Name string `gorm:"column:Name;type:varchar(128)"`
+	// 类型
+	Op GORMCacheBusOp `gorm:"column:Op"`
+	// json 格式的 []K
+	Keys string `gorm:"column:Keys"`
+}
+
+// GORMCacheDBBus 是使用数据表轮询的 GORMCacheBus ，
+// 发布就是插入一行，每个节点轮询比上次大的 ID 。
+// 注意，并发插入的事务提交顺序和 ID 顺序可能不一致，
+// 极端情况下会漏掉事件，所以最好配合 Refresh 使用
+type GORMCacheDBBus struct {
+	gormCacheBusSubs
+	db *gorm.DB
+	// 轮询间隔
+	interval time.Duration
+	// 保留时间
+	keep time.Duration
+	// 上次轮询的最大 ID
+	last int64
+	// 退出
+	quit *Signal
+	wait sync.WaitGroup
+}
+
+// NewGORMCacheDBBus 创建数据表，然后启动协程轮询，
+// interval 是轮询间隔，keep 是事件的保留时间，超时的会被删除
+func NewGORMCacheDBBus(db *gorm.DB, interval, keep time.Duration) (*GORMCacheDBBus, error) {
+	err := db.AutoMigrate(new(GORMCacheBusEventModel))
+	if err != nil {
+		return nil, err
+	}
+	b := new(GORMCacheDBBus)
+	b.db = db
+	b.interval = interval
+	b.keep = keep
+	b.quit = NewSignal()
+	// 从当前开始
+	err = db.Model(new(GORMCacheBusEventModel)).Select("COALESCE(MAX(?), 0)", clause.Column{Name: "ID"}).Scan(&b.last).Error
+	if err != nil {
+		return nil, err
+	}
+	// 启动
+	b.wait.Add(1)
+	go b.pollRoutine()
+	//
+	return b, nil
+}
+
+// Publish 实现 GORMCacheBus
+func (b *GORMCacheDBBus) Publish(e *GORMCacheBusEvent) error {
+	return b.db.Create(&GORMCacheBusEventModel{
+		Node: e.Node,
+		Name: e.Name,
+		Op:   e.Op,
+		Keys: string(e.Keys),
+	}).Error
+}
+
+// Close 停止轮询协程
+func (b *GORMCacheDBBus) Close() {
+	b.quit.Close()
+	b.wait.Wait()
+}
+
+// pollRoutine 在协程中轮询
+func (b *GORMCacheDBBus) pollRoutine() {
+	defer b.wait.Done()
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	var clean time.Time
+	for {
+		select {
+		case <-b.quit.C:
+			return
+		case now := <-ticker.C:
+			b.poll()
+			// 清理
+			if b.keep > 0 && now.Sub(clean) > b.keep/2 {
+				b.db.Where(clause.Lt{Column: "CreatedAt", Value: now.Add(-b.keep).Unix()}).Delete(new(GORMCacheBusEventModel))
+				clean = now
+			}
+		}
+	}
+}
+
+// poll 查询并分发新的事件
+func (b *GORMCacheDBBus) poll() {
+	for {
+		var ms []*GORMCacheBusEventModel
+		err := b.db.Where(clause.Gt{Column: "ID", Value: b.last}).Order(clause.OrderByColumn{Column: clause.Column{Name: "ID"}}).Limit(GORMCacheBatchSize).Find(&ms).Error
+		if err != nil || len(ms) < 1 {
+			return
+		}
+		for _, m := range ms {
+			b.last = m.ID
+			b.dispatch(&GORMCacheBusEvent{
+				Node: m.Node,
+				Name: m.Name,
+				Op:   m.Op,
+				Keys: json.RawMessage(m.Keys),
+			})
+		}
+		if len(ms) < GORMCacheBatchSize {
+			return
+		}
+	}
+}
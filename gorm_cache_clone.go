@@ -0,0 +1,25 @@
+package util
+
+import "reflect"
+
+// GORMCacheDeepCopy 返回使用 newFunc 和 DeepCopyStruct 拷贝的函数，用于 GORMCache.Clone 。
+// M 必须是结构体指针，newFunc 应该返回零值，不能访问的字段是浅拷贝
+func GORMCacheDeepCopy[M any](newFunc func() M) func(M) M {
+	return func(m M) M {
+		v := reflect.ValueOf(m)
+		if v.Kind() == reflect.Pointer && v.IsNil() {
+			return m
+		}
+		n := newFunc()
+		DeepCopyStruct(n, m)
+		return n
+	}
+}
+
+// clone 设置了 Clone 返回副本，否则返回 m
+func (c *GORMCache[K, M]) clone(m M) M {
+	if c.Clone == nil {
+		return m
+	}
+	return c.Clone(m)
+}
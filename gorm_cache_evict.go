@@ -0,0 +1,185 @@
+package util
+
+import (
+	"container/heap"
+	"container/list"
+	"sync"
+)
+
+// GORMCacheEvict 是 GORMCache 有限模式的淘汰策略
+type GORMCacheEvict int
+
+const (
+	// GORMCacheLRU 淘汰最久没有访问的
+	GORMCacheLRU GORMCacheEvict = iota
+	// GORMCacheLFU 淘汰访问次数最少的，次数相同淘汰最久没有访问的
+	GORMCacheLFU
+)
+
+// gormCacheEvictor 记录访问，并返回要淘汰的 key
+// 读锁下也会调用 touch ，所以实现需要自己保证并发安全
+type gormCacheEvictor[K comparable] interface {
+	// 添加或者访问
+	touch(K)
+	// 删除
+	del(K)
+	// 返回要淘汰的
+	evict() (K, bool)
+	// 清空
+	reset()
+}
+
+// newGORMCacheEvictor 根据策略返回
+func newGORMCacheEvictor[K comparable](evict GORMCacheEvict) gormCacheEvictor[K] {
+	if evict == GORMCacheLFU {
+		e := new(gormCacheLFU[K])
+		e.reset()
+		return e
+	}
+	e := new(gormCacheLRU[K])
+	e.reset()
+	return e
+}
+
+// gormCacheLRU 实现 LRU
+type gormCacheLRU[K comparable] struct {
+	sync.Mutex
+	// 前面是最近访问的
+	l *list.List
+	d map[K]*list.Element
+}
+
+func (e *gormCacheLRU[K]) touch(k K) {
+	e.Lock()
+	if v, ok := e.d[k]; ok {
+		e.l.MoveToFront(v)
+	} else {
+		e.d[k] = e.l.PushFront(k)
+	}
+	e.Unlock()
+}
+
+func (e *gormCacheLRU[K]) del(k K) {
+	e.Lock()
+	if v, ok := e.d[k]; ok {
+		e.l.Remove(v)
+		delete(e.d, k)
+	}
+	e.Unlock()
+}
+
+func (e *gormCacheLRU[K]) evict() (k K, ok bool) {
+	e.Lock()
+	v := e.l.Back()
+	if v != nil {
+		k = e.l.Remove(v).(K)
+		delete(e.d, k)
+		ok = true
+	}
+	e.Unlock()
+	return
+}
+
+func (e *gormCacheLRU[K]) reset() {
+	e.Lock()
+	e.l = list.New()
+	e.d = make(map[K]*list.Element)
+	e.Unlock()
+}
+
+// gormCacheLFUItem 是 gormCacheLFU 的堆元素
+type gormCacheLFUItem[K comparable] struct {
+	k K
+	// 访问次数
+	n int64
+	// 最后访问的序号
+	t int64
+	// 在堆中的下标
+	i int
+}
+
+// gormCacheLFUHeap 最小堆
+type gormCacheLFUHeap[K comparable] []*gormCacheLFUItem[K]
+
+func (h gormCacheLFUHeap[K]) Len() int { return len(h) }
+
+func (h gormCacheLFUHeap[K]) Less(i, j int) bool {
+	if h[i].n == h[j].n {
+		return h[i].t < h[j].t
+	}
+	return h[i].n < h[j].n
+}
+
+func (h gormCacheLFUHeap[K]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].i = i
+	h[j].i = j
+}
+
+func (h *gormCacheLFUHeap[K]) Push(x any) {
+	v := x.(*gormCacheLFUItem[K])
+	v.i = len(*h)
+	*h = append(*h, v)
+}
+
+func (h *gormCacheLFUHeap[K]) Pop() any {
+	old := *h
+	n := len(old)
+	v := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return v
+}
+
+// gormCacheLFU 实现 LFU
+type gormCacheLFU[K comparable] struct {
+	sync.Mutex
+	h gormCacheLFUHeap[K]
+	d map[K]*gormCacheLFUItem[K]
+	// 访问序号
+	t int64
+}
+
+func (e *gormCacheLFU[K]) touch(k K) {
+	e.Lock()
+	e.t++
+	if v, ok := e.d[k]; ok {
+		v.n++
+		v.t = e.t
+		heap.Fix(&e.h, v.i)
+	} else {
+		v = &gormCacheLFUItem[K]{k: k, n: 1, t: e.t}
+		heap.Push(&e.h, v)
+		e.d[k] = v
+	}
+	e.Unlock()
+}
+
+func (e *gormCacheLFU[K]) del(k K) {
+	e.Lock()
+	if v, ok := e.d[k]; ok {
+		heap.Remove(&e.h, v.i)
+		delete(e.d, k)
+	}
+	e.Unlock()
+}
+
+func (e *gormCacheLFU[K]) evict() (k K, ok bool) {
+	e.Lock()
+	if len(e.h) > 0 {
+		v := heap.Pop(&e.h).(*gormCacheLFUItem[K])
+		delete(e.d, v.k)
+		k = v.k
+		ok = true
+	}
+	e.Unlock()
+	return
+}
+
+func (e *gormCacheLFU[K]) reset() {
+	e.Lock()
+	e.h = nil
+	e.d = make(map[K]*gormCacheLFUItem[K])
+	e.t = 0
+	e.Unlock()
+}
@@ -0,0 +1,178 @@
+package util
+
+import (
+	"context"
+	"fmt"
+)
+
+// gormCacheIndex 是 GORMCache 的二级索引
+type gormCacheIndex[K comparable, M any] struct {
+	// 是否唯一
+	unique bool
+	// 返回索引值
+	key func(M) any
+	// 索引值对应的主键
+	d map[any]map[K]struct{}
+	// 主键对应的索引值，用于删除，数据被原地修改也能找到旧的索引值
+	r map[K]any
+}
+
+// add 添加，唯一索引会替换掉相同索引值的旧数据
+func (i *gormCacheIndex[K, M]) add(k K, m M) {
+	i.del(k)
+	v := i.key(m)
+	d := i.d[v]
+	if i.unique {
+		for k := range d {
+			delete(i.r, k)
+		}
+		d = nil
+	}
+	if d == nil {
+		d = make(map[K]struct{})
+		i.d[v] = d
+	}
+	d[k] = struct{}{}
+	i.r[k] = v
+}
+
+// del 删除
+func (i *gormCacheIndex[K, M]) del(k K) {
+	v, ok := i.r[k]
+	if !ok {
+		return
+	}
+	delete(i.r, k)
+	d := i.d[v]
+	delete(d, k)
+	if len(d) < 1 {
+		delete(i.d, v)
+	}
+}
+
+// reset 使用 d 重新建立
+func (i *gormCacheIndex[K, M]) reset(d map[K]M) {
+	i.d = make(map[any]map[K]struct{})
+	i.r = make(map[K]any)
+	for k, m := range d {
+		i.add(k, m)
+	}
+}
+
+// AddIndex 添加二级索引，加载和删除的时候自动维护。
+// name 是索引的名称，unique 表示是否唯一索引，key 返回 M 的索引值。
+// 索引值必须是可以比较的类型，查询的时候类型也要一致，比如 int64 和 int 是不同的。
+// 组合索引可以返回数组或者结构，比如 [2]any{m.A, m.B} 。
+// 应该在初始化的时候添加
+func (c *GORMCache[K, M]) AddIndex(name string, unique bool, key func(M) any) {
+	i := &gormCacheIndex[K, M]{
+		unique: unique,
+		key:    key,
+	}
+	c.Lock()
+	if c.index == nil {
+		c.index = make(map[string]*gormCacheIndex[K, M])
+	}
+	i.reset(c.D)
+	c.index[name] = i
+	c.Unlock()
+}
+
+// indexSet 设置索引，需要先上写锁
+func (c *GORMCache[K, M]) indexSet(k K, m M) {
+	for _, i := range c.index {
+		i.add(k, m)
+	}
+}
+
+// indexDel 删除索引，需要先上写锁
+func (c *GORMCache[K, M]) indexDel(k K) {
+	for _, i := range c.index {
+		i.del(k)
+	}
+}
+
+// indexReset 重新建立索引，需要先上写锁
+func (c *GORMCache[K, M]) indexReset() {
+	for _, i := range c.index {
+		i.reset(c.D)
+	}
+}
+
+// getIndex 返回索引，需要先上读锁
+func (c *GORMCache[K, M]) getIndex(name string) (*gormCacheIndex[K, M], error) {
+	i := c.index[name]
+	if i == nil {
+		return nil, fmt.Errorf("gorm cache index %s not found", name)
+	}
+	return i, nil
+}
+
+// GetByIndex 返回唯一索引的数据，不存在返回 ErrGORMCacheNotFound ，
+// 非唯一索引返回其中一个，没有设置 Clone 时不要修改返回的指针，同步
+func (c *GORMCache[K, M]) GetByIndex(name string, v any) (M, error) {
+	return c.GetByIndexWithContext(context.Background(), name, v)
+}
+
+// GetByIndexWithContext 返回唯一索引的数据，不存在返回 ErrGORMCacheNotFound ，
+// 非唯一索引返回其中一个，没有设置 Clone 时不要修改返回的指针，同步
+func (c *GORMCache[K, M]) GetByIndexWithContext(ctx context.Context, name string, v any) (m M, err error) {
+	ms, err := c.listByIndex(ctx, name, v, 1)
+	if err != nil {
+		return
+	}
+	if len(ms) < 1 {
+		err = ErrGORMCacheNotFound
+		return
+	}
+	return ms[0], nil
+}
+
+// ListByIndex 返回索引的所有数据，没有设置 Clone 时不要修改返回的指针，同步
+func (c *GORMCache[K, M]) ListByIndex(name string, v any) ([]M, error) {
+	return c.ListByIndexWithContext(context.Background(), name, v)
+}
+
+// ListByIndexWithContext 返回索引的所有数据，没有设置 Clone 时不要修改返回的指针，同步
+func (c *GORMCache[K, M]) ListByIndexWithContext(ctx context.Context, name string, v any) ([]M, error) {
+	return c.listByIndex(ctx, name, v, 0)
+}
+
+// listByIndex 返回索引的数据，n 大于 0 时最多返回 n 个。
+// 有限模式下，内存中的数据不全，分批查询数据库，逐个比较索引值
+func (c *GORMCache[K, M]) listByIndex(ctx context.Context, name string, v any, n int) (ms []M, err error) {
+	// 有限模式
+	if c.bounded() {
+		c.RLock()
+		i, err := c.getIndex(name)
+		c.RUnlock()
+		if err != nil {
+			return nil, err
+		}
+		err = c.dbEach(ctx, func(m M) bool {
+			if i.key(m) == v {
+				ms = append(ms, m)
+			}
+			return n < 1 || len(ms) < n
+		})
+		return ms, err
+	}
+	// 确保数据
+	err = c.rlock(ctx)
+	if err != nil {
+		return
+	}
+	i, err := c.getIndex(name)
+	if err == nil {
+		for k := range i.d[v] {
+			ms = append(ms, c.clone(c.D[k]))
+			if n > 0 && len(ms) >= n {
+				break
+			}
+		}
+	}
+	// 解锁
+	c.RUnlock()
+	//
+	return
+}
@@ -0,0 +1,603 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// gormCacheListCond 是 gq 标签的一个条件
+type gormCacheListCond struct {
+	// 列
+	field *schema.Field
+	// gormInitQuery 支持的操作，like ，ilike ，prefix 和 suffix 都是 like ，
+	// and 和 or 是分组
+	op string
+	// 查询的值，like 是 %v% 这样的模式
+	value reflect.Value
+	// in ，nin 和 between 的值
+	values []reflect.Value
+	// and 和 or 的条件
+	conds []*gormCacheListCond
+}
+
+// gormCacheListOrder 是排序的一列
+type gormCacheListOrder struct {
+	field *schema.Field
+	desc  bool
+}
+
+// gormCacheList 是在内存中执行的 GORMList
+type gormCacheList struct {
+	ctx    context.Context
+	conds  []*gormCacheListCond
+	orders []*gormCacheListOrder
+	offset int
+	count  int
+}
+
+// GORMMemoryQuery 是可以在内存中执行的查询参数，用于 GORMCache.MemoryList ，
+// 实现它表示 Init 只调用了 GORMInitQuery ，没有其他的条件，
+// 否则内存和数据库的结果不一样，所以没有实现的使用数据库
+type GORMMemoryQuery interface {
+	GORMQuery
+	// 只是标记，不会调用
+	MemoryQuery()
+}
+
+// newGORMCacheList 解析 page 和 query ，有不支持的返回 nil ，
+// 最后按照主键排序，保证顺序一致
+func newGORMCacheList(ctx context.Context, sch *schema.Schema, page *GORMListPage, query GORMQuery) *gormCacheList {
+	if sch.PrioritizedPrimaryField == nil {
+		return nil
+	}
+	l := new(gormCacheList)
+	l.ctx = ctx
+	l.count = -1
+	// 条件
+	if query != nil {
+		if _, ok := query.(GORMMemoryQuery); !ok {
+			return nil
+		}
+		v := reflect.ValueOf(query)
+		if v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return nil
+			}
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			return nil
+		}
+		conds, ok := l.parseQuery(sch, v)
+		if !ok {
+			return nil
+		}
+		l.conds = conds
+	}
+	// 分页
+	if page != nil {
+		if page.Offset != nil {
+			l.offset = *page.Offset
+		}
+		if page.Count != nil {
+			l.count = *page.Count
+		}
+		orders, err := gormListOrders(page, query)
+		if err != nil || !l.parseOrder(sch, orders) {
+			return nil
+		}
+	}
+	l.orders = append(l.orders, &gormCacheListOrder{field: sch.PrioritizedPrimaryField})
+	//
+	return l
+}
+
+// parseQuery 使用 gormInitQuery 的解析结果，返回 v 的每一个字段的条件
+func (l *gormCacheList) parseQuery(sch *schema.Schema, v reflect.Value) ([]*gormCacheListCond, bool) {
+	return l.parsePlan(sch, getGORMQueryPlan(v.Type()), v)
+}
+
+// parsePlan 返回 v 的每一个字段的条件
+func (l *gormCacheList) parsePlan(sch *schema.Schema, p *gormQueryPlan, v reflect.Value) ([]*gormCacheListCond, bool) {
+	// 使用数据库返回错误
+	if p.err != nil {
+		return nil, false
+	}
+	var conds []*gormCacheListCond
+	for _, qf := range p.fields {
+		fv := v.Field(qf.index)
+		if fv.Kind() == reflect.Pointer {
+			// 空指针
+			if fv.IsNil() {
+				continue
+			}
+			fv = fv.Elem()
+		}
+		// 分组
+		if qf.plan != nil {
+			cs, ok := l.parsePlan(sch, qf.plan, fv)
+			if !ok {
+				return nil, false
+			}
+			if len(cs) > 0 {
+				conds = append(conds, &gormCacheListCond{op: qf.group, conds: cs})
+			}
+			continue
+		}
+		fvk := fv.Kind()
+		if fvk == reflect.String || fvk == reflect.Slice {
+			// 空字符串，空切片
+			if fv.Len() < 1 {
+				continue
+			}
+		}
+		// 多个列是 OR
+		or := &gormCacheListCond{op: "or"}
+		for _, name := range qf.columns {
+			f := sch.LookUpField(name)
+			if f == nil || f.DBName == "" {
+				return nil, false
+			}
+			cond := l.parseCond(f, qf.op, fv)
+			if cond == nil {
+				return nil, false
+			}
+			or.conds = append(or.conds, cond)
+		}
+		if len(or.conds) == 1 {
+			conds = append(conds, or.conds[0])
+		} else {
+			conds = append(conds, or)
+		}
+	}
+	return conds, true
+}
+
+// parseCond 解析一个条件，不支持的返回 nil
+func (l *gormCacheList) parseCond(f *schema.Field, op string, fv reflect.Value) *gormCacheListCond {
+	cond := &gormCacheListCond{field: f, op: op, value: fv}
+	k := gormCacheListKind(f.FieldType)
+	switch op {
+	case "like", "ilike", "prefix", "suffix":
+		if k != reflect.String {
+			return nil
+		}
+		// sqlite 的 LIKE 和 LOWER 都只处理 ASCII ，所以 ilike 也一样
+		p := "%%%v%%"
+		if op == "prefix" {
+			p = "%v%%"
+		} else if op == "suffix" {
+			p = "%%%v"
+		}
+		cond.op = "like"
+		cond.value = reflect.ValueOf(fmt.Sprintf(p, fv.Interface()))
+	case "eq", "neq", "gt", "gte", "lt", "lte":
+		if k == reflect.Invalid || k != gormCacheListKind(fv.Type()) {
+			return nil
+		}
+	case "in", "nin", "between":
+		if fv.Kind() != reflect.Slice && fv.Kind() != reflect.Array {
+			return nil
+		}
+		if op == "between" && fv.Len() != 2 {
+			return nil
+		}
+		if k == reflect.Invalid || k != gormCacheListKind(fv.Type().Elem()) {
+			return nil
+		}
+		for i := 0; i < fv.Len(); i++ {
+			cond.values = append(cond.values, fv.Index(i))
+		}
+	case "null", "notnull":
+		if fv.Kind() != reflect.Bool {
+			return nil
+		}
+	case "&":
+		if !gormCacheListIsInt(f.FieldType) || !gormCacheListIsInt(fv.Type()) {
+			return nil
+		}
+	default:
+		return nil
+	}
+	return cond
+}
+
+// parseOrder 使用 GORMListPage 的排序，带上表名的和 GORMRawOrderQuery 的不支持
+func (l *gormCacheList) parseOrder(sch *schema.Schema, orders []clause.OrderByColumn) bool {
+	for _, s := range orders {
+		if s.Column.Raw {
+			return false
+		}
+		o := &gormCacheListOrder{desc: s.Desc}
+		o.field = sch.LookUpField(s.Column.Name)
+		if o.field == nil || o.field.DBName == "" ||
+			gormCacheListKind(o.field.FieldType) == reflect.Invalid {
+			return false
+		}
+		l.orders = append(l.orders, o)
+	}
+	return true
+}
+
+// match 返回 v 是否满足所有的条件，v 是结构
+func (l *gormCacheList) match(v reflect.Value) bool {
+	for _, c := range l.conds {
+		if !l.matchCond(c, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchCond 返回 v 是否满足 c ，和数据库一样，NULL 只满足 null 和 notnull
+func (l *gormCacheList) matchCond(c *gormCacheListCond, v reflect.Value) bool {
+	switch c.op {
+	case "and":
+		for _, c := range c.conds {
+			if !l.matchCond(c, v) {
+				return false
+			}
+		}
+		return true
+	case "or":
+		for _, c := range c.conds {
+			if l.matchCond(c, v) {
+				return true
+			}
+		}
+		return false
+	}
+	fv := c.field.ReflectValueOf(l.ctx, v)
+	switch c.op {
+	case "like":
+		s, ok := gormCacheListIndirect(fv)
+		return ok && gormCacheLike(s.String(), c.value.String())
+	case "null", "notnull":
+		_, ok := gormCacheListIndirect(fv)
+		return ok != (c.value.Bool() == (c.op == "null"))
+	case "in", "nin":
+		for _, e := range c.values {
+			n, ok := gormCacheListCompare(fv, e)
+			if !ok {
+				return false
+			}
+			if n == 0 {
+				return c.op == "in"
+			}
+		}
+		return c.op == "nin"
+	case "between":
+		n1, ok1 := gormCacheListCompare(fv, c.values[0])
+		n2, ok2 := gormCacheListCompare(fv, c.values[1])
+		return ok1 && ok2 && n1 >= 0 && n2 <= 0
+	case "&":
+		x, ok := gormCacheListIndirect(fv)
+		if !ok {
+			return false
+		}
+		y, _ := gormCacheListIndirect(c.value)
+		a, _ := gormCacheListInt(x)
+		b, _ := gormCacheListInt(y)
+		return a&b == b
+	}
+	n, ok := gormCacheListCompare(fv, c.value)
+	if !ok {
+		// NULL
+		return false
+	}
+	switch c.op {
+	case "eq":
+		return n == 0
+	case "neq":
+		return n != 0
+	case "gt":
+		return n < 0
+	case "gte":
+		return n <= 0
+	case "lt":
+		return n > 0
+	default:
+		return n >= 0
+	}
+}
+
+// keys 返回 v 排序的字段的副本，需要在锁内调用，
+// 没有 Clone 的时候 UpdateCache 直接修改数据，排序在锁外
+func (l *gormCacheList) keys(v reflect.Value) []reflect.Value {
+	ks := make([]reflect.Value, len(l.orders))
+	for i, o := range l.orders {
+		fv := o.field.ReflectValueOf(l.ctx, v)
+		iv, ok := gormCacheListIndirect(fv)
+		if !ok {
+			// NULL
+			ks[i] = reflect.Zero(fv.Type())
+			continue
+		}
+		ks[i] = reflect.New(iv.Type()).Elem()
+		ks[i].Set(iv)
+	}
+	return ks
+}
+
+// less 按照排序比较 keys 返回的值，NULL 最小
+func (l *gormCacheList) less(a, b []reflect.Value) bool {
+	for i, o := range l.orders {
+		av, bv := a[i], b[i]
+		n, ok := gormCacheListCompare(av, bv)
+		if !ok {
+			_, an := gormCacheListIndirect(av)
+			_, bn := gormCacheListIndirect(bv)
+			if an == bn {
+				continue
+			}
+			// 不为 NULL 的大
+			n = 1
+			if bn {
+				n = -1
+			}
+		}
+		if n == 0 {
+			continue
+		}
+		if o.desc {
+			return n > 0
+		}
+		return n < 0
+	}
+	return false
+}
+
+// gormCacheListKind 返回比较的类型，数字都是 reflect.Float64 ，不支持的返回 reflect.Invalid
+func gormCacheListKind(t reflect.Type) reflect.Kind {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return reflect.String
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return reflect.Float64
+	}
+	return reflect.Invalid
+}
+
+// gormCacheListIsInt 返回 t 是否整数或者整数指针
+func gormCacheListIsInt(t reflect.Type) bool {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// gormCacheListIndirect 返回指针的值，空指针是 NULL 返回 false
+func gormCacheListIndirect(v reflect.Value) (reflect.Value, bool) {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return v, false
+		}
+		v = v.Elem()
+	}
+	return v, true
+}
+
+// gormCacheListCompare 比较 a 和 b ，有一个是 NULL 返回 false
+func gormCacheListCompare(a, b reflect.Value) (int, bool) {
+	a, ok := gormCacheListIndirect(a)
+	if !ok {
+		return 0, false
+	}
+	b, ok = gormCacheListIndirect(b)
+	if !ok {
+		return 0, false
+	}
+	if a.Kind() == reflect.String {
+		return strings.Compare(a.String(), b.String()), true
+	}
+	// 数字
+	switch a.Kind() {
+	case reflect.Float32, reflect.Float64:
+	default:
+		switch b.Kind() {
+		case reflect.Float32, reflect.Float64:
+		default:
+			return gormCacheListCompareInt(a, b), true
+		}
+	}
+	x, y := gormCacheListFloat(a), gormCacheListFloat(b)
+	if x < y {
+		return -1, true
+	}
+	if x > y {
+		return 1, true
+	}
+	return 0, true
+}
+
+// gormCacheListCompareInt 比较整数，bool 是 0 和 1
+func gormCacheListCompareInt(a, b reflect.Value) int {
+	x, xn := gormCacheListInt(a)
+	y, yn := gormCacheListInt(b)
+	// 负数
+	if xn != yn {
+		if xn {
+			return -1
+		}
+		return 1
+	}
+	if x < y {
+		return -1
+	}
+	if x > y {
+		return 1
+	}
+	return 0
+}
+
+// gormCacheListInt 返回整数的 uint64 ，负数返回 true
+func gormCacheListInt(v reflect.Value) (uint64, bool) {
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return 1, false
+		}
+		return 0, false
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n := v.Int()
+		if n < 0 {
+			// 负数之间，补码的大小关系不变
+			return uint64(n), true
+		}
+		return uint64(n), false
+	default:
+		return v.Uint(), false
+	}
+}
+
+// gormCacheListFloat 返回数字的 float64
+func gormCacheListFloat(v reflect.Value) float64 {
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Bool:
+		if v.Bool() {
+			return 1
+		}
+		return 0
+	default:
+		return float64(v.Uint())
+	}
+}
+
+// gormCacheLike 和 sqlite 的 LIKE 一样，% 匹配任意个字符，_ 匹配一个字符，
+// ASCII 字母不区分大小写
+func gormCacheLike(s, p string) bool {
+	sr, pr := []rune(s), []rune(p)
+	i, j, star, mark := 0, 0, -1, 0
+	for i < len(sr) {
+		if j < len(pr) && pr[j] == '%' {
+			// 记录，先匹配 0 个
+			star = j
+			mark = i
+			j++
+			continue
+		}
+		if j < len(pr) && (pr[j] == '_' || gormCacheLikeFold(pr[j]) == gormCacheLikeFold(sr[i])) {
+			i++
+			j++
+			continue
+		}
+		if star >= 0 {
+			// 回到 % ，多匹配一个
+			j = star + 1
+			mark++
+			i = mark
+			continue
+		}
+		return false
+	}
+	for j < len(pr) && pr[j] == '%' {
+		j++
+	}
+	return j == len(pr)
+}
+
+// gormCacheLikeFold 返回 ASCII 字母的小写
+func gormCacheLikeFold(r rune) rune {
+	if r >= 'A' && r <= 'Z' {
+		return r + 'a' - 'A'
+	}
+	return r
+}
+
+// listMemory 在内存中执行 GORMList ，page 或者 query 不支持返回 false
+func (c *GORMCache[K, M]) listMemory(ctx context.Context, page *GORMListPage, query GORMQuery, res *GORMListData[M]) (bool, error) {
+	sch, err := c.schema()
+	if err != nil {
+		return false, nil
+	}
+	l := newGORMCacheList(ctx, sch, page, query)
+	if l == nil {
+		return false, nil
+	}
+	// 确保数据
+	err = c.rlock(ctx)
+	if err != nil {
+		return true, err
+	}
+	var ms []M
+	var ks [][]reflect.Value
+	for _, m := range c.D {
+		v := reflect.Indirect(reflect.ValueOf(m))
+		if l.match(v) {
+			ms = append(ms, m)
+			ks = append(ks, l.keys(v))
+		}
+	}
+	// 解锁
+	c.RUnlock()
+	// 排序
+	sort.Sort(&gormCacheListSorter[M]{l: l, ms: ms, ks: ks})
+	res.Total = int64(len(ms))
+	// 分页
+	if l.offset > 0 {
+		if l.offset > len(ms) {
+			l.offset = len(ms)
+		}
+		ms = ms[l.offset:]
+	}
+	if l.count >= 0 && l.count < len(ms) {
+		ms = ms[:l.count]
+	}
+	res.Data = make([]M, len(ms))
+	for i, m := range ms {
+		res.Data[i] = c.clone(m)
+	}
+	//
+	return true, nil
+}
+
+// listOrder 返回最后的主键排序，和 listMemory 的顺序一致
+func (c *GORMCache[K, M]) listOrder() []clause.OrderByColumn {
+	sch, err := c.schema()
+	if err != nil || sch.PrioritizedPrimaryField == nil {
+		return nil
+	}
+	return []clause.OrderByColumn{{Column: clause.Column{Table: clause.CurrentTable, Name: sch.PrioritizedPrimaryField.DBName}}}
+}
+
+// gormCacheListSorter 用于排序
+type gormCacheListSorter[M any] struct {
+	l  *gormCacheList
+	ms []M
+	// 排序字段的副本
+	ks [][]reflect.Value
+}
+
+func (s *gormCacheListSorter[M]) Len() int {
+	return len(s.ms)
+}
+
+func (s *gormCacheListSorter[M]) Less(i, j int) bool {
+	return s.l.less(s.ks[i], s.ks[j])
+}
+
+func (s *gormCacheListSorter[M]) Swap(i, j int) {
+	s.ms[i], s.ms[j] = s.ms[j], s.ms[i]
+	s.ks[i], s.ks[j] = s.ks[j], s.ks[i]
+}
@@ -0,0 +1,119 @@
+package util
+
+import (
+	"context"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GORMPartitionCache 是按照分区划分的缓存，比如多租户的表按照 TenantID 划分。
+// 每个分区是一个独立的 GORMCache ，第一次使用的时候创建，
+// 它的 Scope 只包含这个分区的数据，有自己的 OK ，可以单独加载和删除
+type GORMPartitionCache[P comparable, K comparable, M any] struct {
+	// 保护 d
+	l sync.RWMutex
+	// 分区
+	d map[P]*GORMCache[K, M]
+	// 分区的列名
+	column string
+	// 创建分区的缓存
+	newCache func() *GORMCache[K, M]
+	// 设置好 Scope 后启动分区的缓存
+	start func(P, *GORMCache[K, M])
+}
+
+// NewGORMPartitionCache 返回新的分区缓存，column 是分区的列名，
+// newCache 创建分区的缓存，之后会设置 Scope 为 column 等于分区，已经有 Scope 的两个都使用。
+// newCache 不能启动协程，比如 Refresh ，总线和 WarmUp ，它们读取 Scope ，
+// 这些放到 start 中，设置好 Scope 后调用，start 可以为 nil
+func NewGORMPartitionCache[P comparable, K comparable, M any](column string, newCache func() *GORMCache[K, M], start func(P, *GORMCache[K, M])) *GORMPartitionCache[P, K, M] {
+	return &GORMPartitionCache[P, K, M]{
+		d:        make(map[P]*GORMCache[K, M]),
+		column:   column,
+		newCache: newCache,
+		start:    start,
+	}
+}
+
+// Partition 返回分区的缓存，没有则创建，数据在第一次读取的时候加载
+func (c *GORMPartitionCache[P, K, M]) Partition(p P) *GORMCache[K, M] {
+	c.l.RLock()
+	pc, ok := c.d[p]
+	c.l.RUnlock()
+	if ok {
+		return pc
+	}
+	// 创建
+	c.l.Lock()
+	pc, ok = c.d[p]
+	if !ok {
+		pc = c.newCache()
+		// 还没有启动，可以直接设置
+		scope := pc.Scope
+		eq := clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: c.column}, Value: p}
+		pc.Scope = func(db *gorm.DB) *gorm.DB {
+			if scope != nil {
+				db = scope(db)
+			}
+			return db.Where(eq)
+		}
+		c.d[p] = pc
+	}
+	c.l.Unlock()
+	// 启动，在锁外，WarmUp 可能很慢
+	if !ok && c.start != nil {
+		c.start(p, pc)
+	}
+	//
+	return pc
+}
+
+// Partitions 返回已经创建的分区
+func (c *GORMPartitionCache[P, K, M]) Partitions() []P {
+	c.l.RLock()
+	ps := make([]P, 0, len(c.d))
+	for p := range c.d {
+		ps = append(ps, p)
+	}
+	c.l.RUnlock()
+	return ps
+}
+
+// Reload 重新加载分区，同步
+func (c *GORMPartitionCache[P, K, M]) Reload(p P) error {
+	return c.ReloadWithContext(context.Background(), p)
+}
+
+// ReloadWithContext 重新加载分区，同步
+func (c *GORMPartitionCache[P, K, M]) ReloadWithContext(ctx context.Context, p P) error {
+	return c.Partition(p).LoadAllWithContext(ctx)
+}
+
+// Evict 删除分区，并调用它的 Close ，下一次使用的时候重新创建
+func (c *GORMPartitionCache[P, K, M]) Evict(p P) error {
+	c.l.Lock()
+	pc, ok := c.d[p]
+	delete(c.d, p)
+	c.l.Unlock()
+	if ok {
+		return pc.Close()
+	}
+	return nil
+}
+
+// Close 删除所有的分区，并调用它们的 Close ，返回第一个错误
+func (c *GORMPartitionCache[P, K, M]) Close() error {
+	c.l.Lock()
+	d := c.d
+	c.d = make(map[P]*GORMCache[K, M])
+	c.l.Unlock()
+	var err error
+	for _, pc := range d {
+		if err1 := pc.Close(); err == nil {
+			err = err1
+		}
+	}
+	return err
+}
@@ -0,0 +1,99 @@
+package util
+
+import (
+	"gorm.io/gorm"
+)
+
+// GORMCacheRefer 声明 a 的数据引用了 b 的数据，比如 a 使用 Preload 或者 Joins 加载了 b 的关联，
+// b 的内存数据修改后，从数据库重新加载 a 中 refer 返回 true 的数据。
+// refer 判断 a 的数据 m 是否和 b 的修改 e 有关，比如 belongs to 可以是 m.UserID == e.Key ，
+// has many 可以判断 e.Old 或者 e.New 的外键等于 m.ID ，在锁外回调，可以再调用 a 的函数。
+// n 是订阅 b 的通道大小，通道满了有丢弃的时候，重新加载 a 的全部数据。
+// 在协程中加载，a 或者 b 调用 Close 之后退出，返回的函数用于取消
+func GORMCacheRefer[AK comparable, AM any, BK comparable, BM any](
+	a *GORMCache[AK, AM],
+	b *GORMCache[BK, BM],
+	n int,
+	refer func(m AM, e *GORMCacheChange[BK, BM]) bool,
+) func() {
+	s := b.Subscribe(n)
+	a.wait.Add(1)
+	go gormCacheReferRoutine(a, s, refer)
+	return s.Close
+}
+
+// gormCacheReferRoutine 在协程中接收 b 的修改，重新加载 a
+func gormCacheReferRoutine[AK comparable, AM any, BK comparable, BM any](
+	a *GORMCache[AK, AM],
+	s *GORMCacheSubscription[BK, BM],
+	refer func(AM, *GORMCacheChange[BK, BM]) bool,
+) {
+	defer a.wait.Done()
+	//
+	var drops int64
+	for {
+		select {
+		case <-a.quit.C:
+			s.Close()
+			return
+		case e, ok := <-s.C:
+			if !ok {
+				return
+			}
+			// 合并已经到达的
+			es := []*GORMCacheChange[BK, BM]{e}
+		Loop:
+			for {
+				select {
+				case e, ok = <-s.C:
+					if !ok {
+						break Loop
+					}
+					es = append(es, e)
+				default:
+					break Loop
+				}
+			}
+			// 有丢弃，不知道修改了哪些
+			if d := s.Dropped(); d != drops {
+				drops = d
+				a.LoadAll()
+				continue
+			}
+			gormCacheReferLoad(a, es, refer)
+		}
+	}
+}
+
+// gormCacheReferLoad 重新加载 a 中引用了 es 的数据
+func gormCacheReferLoad[AK comparable, AM any, BK comparable, BM any](
+	a *GORMCache[AK, AM],
+	es []*GORMCacheChange[BK, BM],
+	refer func(AM, *GORMCacheChange[BK, BM]) bool,
+) {
+	// 在锁内复制，refer 在锁外回调，里面可以再调用 a 的函数
+	a.RLock()
+	aks := make([]AK, 0, len(a.D))
+	ams := make([]AM, 0, len(a.D))
+	for k, m := range a.D {
+		aks = append(aks, k)
+		ams = append(ams, m)
+	}
+	a.RUnlock()
+	var ks []AK
+	for i, m := range ams {
+		for _, e := range es {
+			if refer(m, e) {
+				ks = append(ks, aks[i])
+				break
+			}
+		}
+	}
+	if len(ks) < 1 {
+		return
+	}
+	// 失败会标记 OK ，下次使用的时候全部加载
+	a.LoadWhere(func(db *gorm.DB) *gorm.DB {
+		return a.WhereKeys(db, ks)
+	})
+}
@@ -0,0 +1,60 @@
+package util
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// Refresh 启动协程，每隔 interval 加上 [0,jitter) 的随机时间，
+// 调用一次 LoadAllWithContext 。
+// ctx 结束或者调用 Close 后协程退出，onError 用于接收加载的错误，可以为 nil 。
+// 加载失败会标记 OK 为 false ，下一次读取会重新加载，而不是继续使用旧的数据。
+func (c *GORMCache[K, M]) Refresh(ctx context.Context, interval, jitter time.Duration, onError func(error)) {
+	c.wait.Add(1)
+	go c.refreshRoutine(ctx, interval, jitter, onError)
+}
+
+// refreshRoutine 在协程中定时加载
+func (c *GORMCache[K, M]) refreshRoutine(ctx context.Context, interval, jitter time.Duration, onError func(error)) {
+	defer c.wait.Done()
+	//
+	timer := time.NewTimer(refreshDuration(interval, jitter))
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.quit.C:
+			return
+		case <-timer.C:
+			// 加载
+			err := c.LoadAllWithContext(ctx)
+			if err != nil && onError != nil {
+				onError(err)
+			}
+			timer.Reset(refreshDuration(interval, jitter))
+		}
+	}
+}
+
+// refreshDuration 返回 interval 加上 [0,jitter) 的随机时间
+func refreshDuration(interval, jitter time.Duration) time.Duration {
+	if jitter > 0 {
+		interval += time.Duration(rand.Int63n(int64(jitter)))
+	}
+	return interval
+}
+
+// Close 取消消息总线的订阅，停止所有的后台协程，并等待它们退出，
+// 然后写入延迟写剩下的数据，返回写入的错误，最后取消所有的 Subscribe
+func (c *GORMCache[K, M]) Close() error {
+	if c.busCancel != nil {
+		c.busCancel()
+	}
+	c.quit.Close()
+	c.wait.Wait()
+	err := c.Flush()
+	c.closeSubs()
+	return err
+}
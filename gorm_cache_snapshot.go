@@ -0,0 +1,227 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrGORMCacheNotFull 表示只支持开启缓存并且不是有限模式
+	ErrGORMCacheNotFull = errors.New("gorm cache requires full cache mode")
+	// ErrGORMCacheNoPrimaryKey 表示模型没有主键
+	ErrGORMCacheNoPrimaryKey = errors.New("gorm cache model has no primary key")
+)
+
+// Dump 使用 gob 格式把内存中的数据写入 w ，用于下次启动时 Restore ，
+// 持有读锁编码到内存中，写入在锁外，同步
+func (c *GORMCache[K, M]) Dump(w io.Writer) error {
+	return c.DumpWithContext(context.Background(), w)
+}
+
+// DumpWithContext 使用 gob 格式把内存中的数据写入 w ，用于下次启动时 Restore ，
+// 持有读锁编码到内存中，写入在锁外，同步
+func (c *GORMCache[K, M]) DumpWithContext(ctx context.Context, w io.Writer) error {
+	if !c.Cache || c.bounded() {
+		return ErrGORMCacheNotFull
+	}
+	// 确保数据
+	err := c.rlock(ctx)
+	if err != nil {
+		return err
+	}
+	ms := make([]M, 0, len(c.D))
+	for _, m := range c.D {
+		ms = append(ms, m)
+	}
+	// 在锁内编码，没有设置 Clone 的时候 UpdateCache 直接修改数据
+	var buf bytes.Buffer
+	err = gob.NewEncoder(&buf).Encode(ms)
+	// 解锁，w 可能很慢，不能一直持有锁
+	c.RUnlock()
+	if err != nil {
+		return err
+	}
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// DumpFile 调用 Dump 写入文件，先写入临时文件再重命名，不会留下不完整的文件，同步
+func (c *GORMCache[K, M]) DumpFile(name string) error {
+	tmp := name + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = c.Dump(f)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+// Restore 读取 Dump 的数据替换内存，并标记 OK 为 true ，
+// 之后需要调用 Reconcile 和数据库比较，同步
+func (c *GORMCache[K, M]) Restore(r io.Reader) error {
+	if !c.Cache || c.bounded() {
+		return ErrGORMCacheNotFull
+	}
+	var ms []M
+	err := gob.NewDecoder(r).Decode(&ms)
+	if err != nil {
+		return err
+	}
+	// 上锁
+	c.lockLoad()
+	c.replace(ms)
+	// 解锁
+	c.unlockLoad()
+	//
+	return nil
+}
+
+// RestoreFile 调用 Restore 读取文件，同步
+func (c *GORMCache[K, M]) RestoreFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.Restore(f)
+}
+
+// Reconcile 比较数据库和内存的 UpdatedAt ，
+// 加载不一致和内存中没有的，删除数据库中已经没有的，
+// 只查询主键和 UpdatedAt ，比全部加载的代价小很多。
+// 模型没有 UpdatedAt 字段返回 ErrGORMCacheNoUpdatedAt ，
+// 没有主键返回 ErrGORMCacheNoPrimaryKey ，同步
+func (c *GORMCache[K, M]) Reconcile() error {
+	return c.ReconcileWithContext(context.Background())
+}
+
+// ReconcileWithContext 比较数据库和内存的 UpdatedAt ，
+// 加载不一致和内存中没有的，删除数据库中已经没有的，
+// 只查询主键和 UpdatedAt ，比全部加载的代价小很多。
+// 模型没有 UpdatedAt 字段返回 ErrGORMCacheNoUpdatedAt ，
+// 没有主键返回 ErrGORMCacheNoPrimaryKey ，同步
+func (c *GORMCache[K, M]) ReconcileWithContext(ctx context.Context) error {
+	if !c.Cache || c.bounded() {
+		return ErrGORMCacheNotFull
+	}
+	sch, err := c.schema()
+	if err != nil {
+		return err
+	}
+	updatedAt := sch.LookUpField("UpdatedAt")
+	if updatedAt == nil {
+		return ErrGORMCacheNoUpdatedAt
+	}
+	// 复合主键也可以
+	if len(sch.PrimaryFieldDBNames) < 1 {
+		return ErrGORMCacheNoPrimaryKey
+	}
+	columns := append([]string{updatedAt.DBName}, sch.PrimaryFieldDBNames...)
+	version := func(m M) any {
+		v, _ := updatedAt.ValueOf(ctx, reflect.Indirect(reflect.ValueOf(m)))
+		return v
+	}
+	// 开始时的数据，用于判断删除的时候数据没有被修改过
+	c.RLock()
+	old := make(map[K]M, len(c.D))
+	for k, m := range c.D {
+		old[k] = m
+	}
+	c.RUnlock()
+	// 数据库
+	var ms []M
+	var change []K
+	seen := make(map[K]struct{}, len(old))
+	err = c.ModelWithContext(ctx).
+		Select(columns).
+		FindInBatches(&ms, GORMCacheBatchSize, func(tx *gorm.DB, batch int) error {
+			for _, m := range ms {
+				k := c.Key(m)
+				seen[k] = struct{}{}
+				o, ok := old[k]
+				if !ok || !gormCacheSameVersion(version(o), version(m)) {
+					change = append(change, k)
+				}
+			}
+			return nil
+		}).Error
+	if err != nil {
+		return err
+	}
+	// 加载
+	for i := 0; i < len(change); i += GORMCacheBatchSize {
+		ks := change[i:]
+		if len(ks) > GORMCacheBatchSize {
+			ks = ks[:GORMCacheBatchSize]
+		}
+		err = c.LoadWhereWithContext(ctx, func(db *gorm.DB) *gorm.DB {
+			return c.WhereKeys(db, ks)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	// 删除，期间被重新加载过的 UpdatedAt 不一样，不删除。
+	// 不能比较 M ，它可能是不能比较的类型
+	c.Lock()
+	for k, m := range old {
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		if v, ok := c.D[k]; ok && c.Key(v) == k && gormCacheSameVersion(version(v), version(m)) {
+			c.del(k)
+		}
+	}
+	c.unlock()
+	//
+	return nil
+}
+
+// WarmUp 使用 RestoreFile 恢复内存，然后启动协程调用 Reconcile 和数据库比较，
+// 服务可以立即使用内存中的数据。
+// 恢复失败返回错误，比如文件不存在，此时应该使用 LoadAll 。
+// ctx 用于 Reconcile ，onError 用于接收它的错误，可以为 nil
+func (c *GORMCache[K, M]) WarmUp(ctx context.Context, name string, onError func(error)) error {
+	err := c.RestoreFile(name)
+	if err != nil {
+		return err
+	}
+	c.wait.Add(1)
+	go c.reconcileRoutine(ctx, onError)
+	return nil
+}
+
+// reconcileRoutine 在协程中调用 Reconcile
+func (c *GORMCache[K, M]) reconcileRoutine(ctx context.Context, onError func(error)) {
+	defer c.wait.Done()
+	err := c.ReconcileWithContext(ctx)
+	if err != nil && onError != nil {
+		onError(err)
+	}
+}
+
+// gormCacheSameVersion 比较 UpdatedAt
+func gormCacheSameVersion(a, b any) bool {
+	if t, ok := a.(time.Time); ok {
+		if t2, ok := b.(time.Time); ok {
+			return t.Equal(t2)
+		}
+		return false
+	}
+	return a == b
+}
@@ -0,0 +1,137 @@
+package util
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// gormCacheEvent 是写锁期间记录的事件
+type gormCacheEvent[K comparable, M any] struct {
+	// true 是加载，false 是移除
+	load bool
+	// 加载失败，不为 nil 的时候是 OnReloadError
+	err error
+	k   K
+	m   M
+}
+
+// gormCacheStats 是 GORMCache 的统计计数
+type gormCacheStats struct {
+	hits           atomic.Int64
+	misses         atomic.Int64
+	reloads        atomic.Int64
+	partialReloads atomic.Int64
+	reloadErrors   atomic.Int64
+	reloadNanos    atomic.Int64
+	evictions      atomic.Int64
+}
+
+// observe 加载成功后增加 n 和耗时，使用 defer 调用
+func (s *gormCacheStats) observe(start time.Time, n *atomic.Int64, err *error) {
+	if *err == nil {
+		n.Add(1)
+		s.reloadNanos.Add(int64(time.Since(start)))
+	}
+}
+
+// GORMCacheStats 是 GORMCache 的统计
+type GORMCacheStats struct {
+	// Get 在内存中命中的次数
+	Hits int64 `json:"hits"`
+	// Get 没有命中的次数，包括过期和不启用缓存
+	Misses int64 `json:"misses"`
+	// 全部加载的次数
+	Reloads int64 `json:"reloads"`
+	// 部分加载的次数，单个或者条件
+	PartialReloads int64 `json:"partialReloads"`
+	// 加载失败的次数
+	ReloadErrors int64 `json:"reloadErrors"`
+	// 加载成功的总耗时
+	ReloadDuration time.Duration `json:"reloadDuration"`
+	// 有限模式淘汰的数量
+	Evictions int64 `json:"evictions"`
+	// 当前内存中的数量
+	Count int64 `json:"count"`
+}
+
+// Stats 返回统计
+func (c *GORMCache[K, M]) Stats() GORMCacheStats {
+	var s GORMCacheStats
+	s.Hits = c.stats.hits.Load()
+	s.Misses = c.stats.misses.Load()
+	s.Reloads = c.stats.reloads.Load()
+	s.PartialReloads = c.stats.partialReloads.Load()
+	s.ReloadErrors = c.stats.reloadErrors.Load()
+	s.ReloadDuration = time.Duration(c.stats.reloadNanos.Load())
+	s.Evictions = c.stats.evictions.Load()
+	c.RLock()
+	s.Count = int64(len(c.D))
+	c.RUnlock()
+	return s
+}
+
+// GORMCacheStatser 用于 GORMCacheStatsHandler ，GORMCache 实现了
+type GORMCacheStatser interface {
+	Stats() GORMCacheStats
+}
+
+// gormCacheMetrics 是 prometheus 的指标
+var gormCacheMetrics = []struct {
+	name string
+	typ  string
+	help string
+	val  func(*GORMCacheStats) any
+}{
+	{"gorm_cache_hits_total", "counter", "Number of cache hits.", func(s *GORMCacheStats) any { return s.Hits }},
+	{"gorm_cache_misses_total", "counter", "Number of cache misses.", func(s *GORMCacheStats) any { return s.Misses }},
+	{"gorm_cache_reloads_total", "counter", "Number of full reloads.", func(s *GORMCacheStats) any { return s.Reloads }},
+	{"gorm_cache_partial_reloads_total", "counter", "Number of partial reloads.", func(s *GORMCacheStats) any { return s.PartialReloads }},
+	{"gorm_cache_reload_errors_total", "counter", "Number of failed reloads.", func(s *GORMCacheStats) any { return s.ReloadErrors }},
+	{"gorm_cache_reload_seconds_total", "counter", "Total time spent on successful reloads.", func(s *GORMCacheStats) any { return s.ReloadDuration.Seconds() }},
+	{"gorm_cache_evictions_total", "counter", "Number of evictions in bounded mode.", func(s *GORMCacheStats) any { return s.Evictions }},
+	{"gorm_cache_entries", "gauge", "Number of entries in memory.", func(s *GORMCacheStats) any { return s.Count }},
+}
+
+// gormCacheLabelEscaper 是 prometheus 文本格式的标签值转义，只转义 \ ，" 和换行
+var gormCacheLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// WriteGORMCacheStats 以 prometheus 文本格式写入统计，cs 的 key 是 cache 标签
+func WriteGORMCacheStats(w io.Writer, cs map[string]GORMCacheStatser) error {
+	// 排序，保证输出一致
+	names := make([]string, 0, len(cs))
+	for name := range cs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ss := make([]GORMCacheStats, len(names))
+	for i, name := range names {
+		ss[i] = cs[name].Stats()
+	}
+	// 输出
+	for _, m := range gormCacheMetrics {
+		_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.typ)
+		if err != nil {
+			return err
+		}
+		for i, name := range names {
+			_, err = fmt.Fprintf(w, "%s{cache=\"%s\"} %v\n", m.name, gormCacheLabelEscaper.Replace(name), m.val(&ss[i]))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// GORMCacheStatsHandler 返回以 prometheus 文本格式输出统计的 http.Handler
+func GORMCacheStatsHandler(cs map[string]GORMCacheStatser) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteGORMCacheStats(w, cs)
+	})
+}
@@ -0,0 +1,114 @@
+package util
+
+import (
+	"sync/atomic"
+)
+
+// GORMCacheOp 是 GORMCacheChange 的类型
+type GORMCacheOp int
+
+const (
+	// GORMCacheOpAdd 内存中添加了数据
+	GORMCacheOpAdd GORMCacheOp = iota
+	// GORMCacheOpUpdate 内存中的数据修改了
+	GORMCacheOpUpdate
+	// GORMCacheOpDelete 数据删除了，或者数据库中已经没有了
+	GORMCacheOpDelete
+	// GORMCacheOpEvict 数据只是从内存中移除，比如有限模式的淘汰和 InvalidateAll
+	GORMCacheOpEvict
+)
+
+// GORMCacheChange 是内存数据的修改
+type GORMCacheChange[K comparable, M any] struct {
+	// 类型
+	Op GORMCacheOp
+	// 主键
+	Key K
+	// 修改前的数据，GORMCacheOpAdd 是零值
+	Old M
+	// 修改后的数据，GORMCacheOpDelete 和 GORMCacheOpEvict 是零值
+	New M
+}
+
+// GORMCacheSubscription 是 GORMCache 的订阅
+type GORMCacheSubscription[K comparable, M any] struct {
+	c *GORMCache[K, M]
+	// 接收修改
+	ch *SafeChan[*GORMCacheChange[K, M]]
+	// 通道满了丢弃的数量
+	drops atomic.Int64
+	// C 用于接收修改，取消订阅后关闭
+	C <-chan *GORMCacheChange[K, M]
+}
+
+// Dropped 返回通道满了丢弃的数量
+func (s *GORMCacheSubscription[K, M]) Dropped() int64 {
+	return s.drops.Load()
+}
+
+// Close 取消订阅，并关闭 C
+func (s *GORMCacheSubscription[K, M]) Close() {
+	s.c.subLock.Lock()
+	if _, ok := s.c.subs[s]; ok {
+		delete(s.c.subs, s)
+		s.c.subN.Add(-1)
+	}
+	s.c.subLock.Unlock()
+	s.ch.Close()
+}
+
+// Subscribe 订阅内存数据的修改，包括加载，更新，删除和淘汰，
+// n 是通道的缓存大小，通道满了不会阻塞，而是丢弃，使用 Dropped 查看丢弃的数量。
+// 加载的数据和内存中的一样时不会通知。
+// 没有设置 Clone 的时候，UpdateCache 是原地修改，Old 和 New 是同一个指针。
+// 修改在锁外发送，不要修改 Old 和 New
+func (c *GORMCache[K, M]) Subscribe(n int) *GORMCacheSubscription[K, M] {
+	s := &GORMCacheSubscription[K, M]{
+		c:  c,
+		ch: NewSafeChan[*GORMCacheChange[K, M]](n),
+	}
+	s.C = s.ch.C
+	c.subLock.Lock()
+	if c.subs == nil {
+		c.subs = make(map[*GORMCacheSubscription[K, M]]struct{})
+	}
+	c.subs[s] = struct{}{}
+	c.subN.Add(1)
+	c.subLock.Unlock()
+	return s
+}
+
+// change 记录修改，需要先上写锁
+func (c *GORMCache[K, M]) change(op GORMCacheOp, k K, old, m M) {
+	if c.subN.Load() > 0 {
+		c.changes = append(c.changes, &GORMCacheChange[K, M]{Op: op, Key: k, Old: old, New: m})
+	}
+}
+
+// notify 发送修改，在锁外调用
+func (c *GORMCache[K, M]) notify(cs []*GORMCacheChange[K, M]) {
+	if len(cs) < 1 {
+		return
+	}
+	c.subLock.RLock()
+	for s := range c.subs {
+		for _, e := range cs {
+			if !s.ch.Send(e) {
+				s.drops.Add(1)
+			}
+		}
+	}
+	c.subLock.RUnlock()
+}
+
+// closeSubs 取消所有的订阅
+func (c *GORMCache[K, M]) closeSubs() {
+	c.subLock.Lock()
+	subs := c.subs
+	c.subs = nil
+	c.subN.Store(0)
+	c.subLock.Unlock()
+	for s := range subs {
+		s.ch.Close()
+	}
+}
@@ -0,0 +1,156 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+var (
+	// ErrGORMCacheNoUpdatedAt 表示模型没有 UpdatedAt 字段
+	ErrGORMCacheNoUpdatedAt = errors.New("gorm cache model has no UpdatedAt field")
+	// ErrGORMCacheUpdatedAtType 表示模型的 UpdatedAt 字段不是整数，比如 time.Time
+	ErrGORMCacheUpdatedAtType = errors.New("gorm cache model UpdatedAt field must be integer")
+	// gormDeletedAtType 用于查找软删除字段
+	gormDeletedAtType = reflect.TypeOf(gorm.DeletedAt{})
+)
+
+// gormCacheSync 是 Sync 的状态
+type gormCacheSync[K comparable, M any] struct {
+	c *GORMCache[K, M]
+	// 间隔
+	interval time.Duration
+	// UpdatedAt 字段
+	updatedAt *schema.Field
+	// 软删除字段，没有是 nil
+	deletedAt *schema.Field
+	// 上一次的 UpdatedAt 最大值
+	lastUpdated int64
+	// 上一次查询软删除的时间
+	lastDeleted time.Time
+}
+
+// Sync 启动协程，每隔 interval 增量加载 UpdatedAt 大于等于上一次最大值的数据，
+// 比全部加载的代价小很多，用于多个进程之间的同步。
+// 如果模型有 gorm.DeletedAt 字段，同时查询软删除的数据，并从内存中删除，
+// 硬删除的数据是查询不到的，需要配合 SetBus 或者 Refresh 使用。
+// ctx 结束或者调用 Close 后协程退出，onError 用于接收加载的错误，可以为 nil 。
+// 模型没有 UpdatedAt 字段返回 ErrGORMCacheNoUpdatedAt ，
+// 不是整数的时间戳返回 ErrGORMCacheUpdatedAtType
+func (c *GORMCache[K, M]) Sync(ctx context.Context, interval time.Duration, onError func(error)) error {
+	sch, err := c.schema()
+	if err != nil {
+		return err
+	}
+	s := new(gormCacheSync[K, M])
+	s.c = c
+	s.interval = interval
+	s.updatedAt = sch.LookUpField("UpdatedAt")
+	if s.updatedAt == nil {
+		return ErrGORMCacheNoUpdatedAt
+	}
+	// 增量查询使用 int64 比较和保存最大值
+	switch s.updatedAt.FieldType.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+	default:
+		return ErrGORMCacheUpdatedAtType
+	}
+	for _, f := range sch.Fields {
+		if f.FieldType == gormDeletedAtType {
+			s.deletedAt = f
+			break
+		}
+	}
+	// 从内存中的最大值开始，没有数据第一次就是全部加载
+	c.RLock()
+	if c.OK {
+		for _, m := range c.D {
+			v := s.updatedAt.ReflectValueOf(ctx, reflect.Indirect(reflect.ValueOf(m)))
+			var n int64
+			if v.CanInt() {
+				n = v.Int()
+			} else {
+				n = int64(v.Uint())
+			}
+			if n > s.lastUpdated {
+				s.lastUpdated = n
+			}
+		}
+	}
+	c.RUnlock()
+	s.lastDeleted = time.Now().Add(-interval)
+	// 启动
+	c.wait.Add(1)
+	go s.routine(ctx, onError)
+	//
+	return nil
+}
+
+// routine 在协程中定时同步
+func (s *gormCacheSync[K, M]) routine(ctx context.Context, onError func(error)) {
+	defer s.c.wait.Done()
+	//
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.c.quit.C:
+			return
+		case <-ticker.C:
+			err := s.sync(ctx)
+			if err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// sync 同步一次
+func (s *gormCacheSync[K, M]) sync(ctx context.Context) error {
+	c := s.c
+	col := clause.Column{Name: s.updatedAt.DBName}
+	// 先查询最大值，之后修改的下一次还会加载
+	var last int64
+	err := c.ModelWithContext(ctx).Select("COALESCE(MAX(?), 0)", col).Scan(&last).Error
+	if err != nil {
+		return err
+	}
+	// 修改的数据，使用大于等于，同一个时间单位内的修改下一次还会加载
+	err = c.LoadWhereWithContext(ctx, func(db *gorm.DB) *gorm.DB {
+		return db.Where(clause.Gte{Column: col, Value: s.lastUpdated})
+	})
+	if err != nil {
+		return err
+	}
+	s.lastUpdated = last
+	// 软删除的数据
+	if s.deletedAt != nil {
+		now := time.Now()
+		var ms []M
+		err = c.ModelWithContext(ctx).Unscoped().
+			Where(clause.Gte{Column: clause.Column{Name: s.deletedAt.DBName}, Value: s.lastDeleted}).
+			Find(&ms).Error
+		if err != nil {
+			return err
+		}
+		if len(ms) > 0 {
+			ks := make([]K, 0, len(ms))
+			for _, m := range ms {
+				ks = append(ks, c.Key(m))
+			}
+			c.BatchDeleteCache(ks)
+		}
+		// 和下一次有重叠，防止提交较晚的漏掉
+		s.lastDeleted = now.Add(-s.interval)
+	}
+	//
+	return nil
+}
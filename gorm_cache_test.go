@@ -0,0 +1,1975 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type testGORMModel struct {
+	GORMBaseModel[int64]
+	Name  string `gorm:""`
+	Phone string `gorm:""`
+}
+
+func newTestGORMDB(t testing.TB) *gorm.DB {
+	db, _, err := InitGORM(filepath.Join(t.TempDir(), "test.db"), NewGORMConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.AutoMigrate(new(testGORMModel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func newTestGORMCache(t testing.TB, db *gorm.DB, n int) *GORMCache[int64, *testGORMModel] {
+	for i := 1; i <= n; i++ {
+		m := new(testGORMModel)
+		m.ID = int64(i)
+		m.Name = fmt.Sprintf("name%d", i)
+		m.Phone = fmt.Sprintf("phone%d", i)
+		err := db.Create(m).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewGORMCache(db, true,
+		func() *testGORMModel { return new(testGORMModel) },
+		func(m *testGORMModel) int64 { return m.ID },
+		WhereID[int64],
+		WhereIDs[int64],
+	)
+}
+
+func Test_GORMCache(t *testing.T) {
+	db := newTestGORMDB(t)
+	c := newTestGORMCache(t, db, 10)
+	// 加载
+	n, err := c.CacheTotal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 10 {
+		t.FailNow()
+	}
+	// 添加
+	m := new(testGORMModel)
+	m.ID = 11
+	m.Name = "name11"
+	_, err = c.Add(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err = c.Get(11)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m == nil || m.Name != "name11" {
+		t.FailNow()
+	}
+	// 修改
+	m = new(testGORMModel)
+	m.ID = 11
+	m.Name = "name11.1"
+	_, err = c.Update(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err = c.Get(11)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m == nil || m.Name != "name11.1" {
+		t.FailNow()
+	}
+	// 删除
+	_, err = c.Delete(11)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err = c.Get(11)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m != nil {
+		t.FailNow()
+	}
+	// 全部加载，会移除数据库中已经不存在的
+	err = db.Delete(new(testGORMModel), 10).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.LoadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err = c.CacheTotal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 9 {
+		t.FailNow()
+	}
+}
+
+func Test_GORMCacheConcurrent(t *testing.T) {
+	db := newTestGORMDB(t)
+	c := newTestGORMCache(t, db, 100)
+	//
+	var w sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		w.Add(1)
+		go func(i int) {
+			defer w.Done()
+			for j := 0; j < 100; j++ {
+				if i%4 == 0 {
+					if err := c.LoadAll(); err != nil {
+						t.Error(err)
+						return
+					}
+					continue
+				}
+				m, err := c.Get(int64(j%100 + 1))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if m == nil {
+					t.Error("not found")
+					return
+				}
+			}
+		}(i)
+	}
+	w.Wait()
+}
+
+// testGORMMutexCache 是修改前的 GORMCache.Get ，互斥锁，
+// 每次都调用 ModelWithContext 和 check ，用于对比
+type testGORMMutexCache struct {
+	sync.Mutex
+	DB *gorm.DB
+	M  *testGORMModel
+	D  map[int64]*testGORMModel
+	OK bool
+}
+
+func (c *testGORMMutexCache) ModelWithContext(ctx context.Context) *gorm.DB {
+	return c.DB.Model(c.M).WithContext(ctx)
+}
+
+func (c *testGORMMutexCache) check(db *gorm.DB) (err error) {
+	if !c.OK {
+		var ms []*testGORMModel
+		err = db.Find(&ms).Error
+		if err == nil {
+			for _, m := range ms {
+				c.D[m.ID] = m
+			}
+		}
+		c.OK = err == nil
+	}
+	return
+}
+
+func (c *testGORMMutexCache) GetWithContext(ctx context.Context, k int64) (m *testGORMModel, err error) {
+	c.Lock()
+	err = c.check(c.ModelWithContext(ctx))
+	if err == nil {
+		m = c.D[k]
+	}
+	c.Unlock()
+	return
+}
+
+// benchmarkGORMCacheGet 并发读取，同时有一个协程不停的写
+func benchmarkGORMCacheGet(b *testing.B, lock sync.Locker, d map[int64]*testGORMModel, get func(int64)) {
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m := &testGORMModel{}
+		for i := int64(0); ; i++ {
+			select {
+			case <-quit:
+				return
+			default:
+			}
+			lock.Lock()
+			m.ID = i%1000 + 1
+			d[m.ID] = m
+			lock.Unlock()
+			time.Sleep(time.Microsecond * 10)
+		}
+	}()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var i int64
+		for pb.Next() {
+			get(i%1000 + 1)
+			i++
+		}
+	})
+	b.StopTimer()
+	close(quit)
+	<-done
+}
+
+func Benchmark_GORMCacheGet(b *testing.B) {
+	db := newTestGORMDB(b)
+	c := newTestGORMCache(b, db, 1000)
+	err := c.LoadAll()
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkGORMCacheGet(b, c, c.D, func(k int64) {
+		c.Get(k)
+	})
+}
+
+func Benchmark_GORMCacheGetMutex(b *testing.B) {
+	db := newTestGORMDB(b)
+	newTestGORMCache(b, db, 1000)
+	c := &testGORMMutexCache{DB: db, M: new(testGORMModel), D: make(map[int64]*testGORMModel)}
+	_, err := c.GetWithContext(context.Background(), 1)
+	if err != nil {
+		b.Fatal(err)
+	}
+	ctx := context.Background()
+	benchmarkGORMCacheGet(b, c, c.D, func(k int64) {
+		c.GetWithContext(ctx, k)
+	})
+}
+
+func Test_GORMCacheTTL(t *testing.T) {
+	db := newTestGORMDB(t)
+	c := newTestGORMCache(t, db, 1)
+	c.TTL = time.Millisecond * 50
+	_, err := c.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 其他进程修改
+	err = db.Model(new(testGORMModel)).Where("`ID` = ?", 1).Update("Name", "ttl").Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 没有过期
+	m, err := c.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Name == "ttl" {
+		t.FailNow()
+	}
+	// 过期
+	time.Sleep(c.TTL * 2)
+	m, err = c.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Name != "ttl" {
+		t.FailNow()
+	}
+}
+
+func Test_GORMCacheTTLError(t *testing.T) {
+	db := newTestGORMDB(t)
+	c := newTestGORMCache(t, db, 2)
+	c.TTL = time.Millisecond * 20
+	var reloadErr error
+	c.OnReloadError = func(err error) { reloadErr = err }
+	_, err := c.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(c.TTL * 2)
+	// ctx 取消
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = c.GetWithContext(ctx, 1)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatal(err)
+	}
+	s := c.Stats()
+	if !c.isOK() || s.Reloads != 1 || s.ReloadErrors != 0 || reloadErr != nil {
+		t.Fatalf("%+v", s)
+	}
+	// 取消的只是等待，合并的查询还会完成并刷新，等它再次过期
+	time.Sleep(c.TTL * 2)
+	// 数据库失败，只影响这一个
+	err = db.Migrator().RenameTable(new(testGORMModel), "testGORMModelBak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Get(1)
+	if err == nil {
+		t.FailNow()
+	}
+	s = c.Stats()
+	if !c.isOK() || s.Reloads != 1 || s.ReloadErrors != 1 || reloadErr == nil || s.Count != 2 {
+		t.Fatalf("%+v", s)
+	}
+	// 恢复后刷新
+	err = db.Migrator().RenameTable("testGORMModelBak", new(testGORMModel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := c.Get(1)
+	if err != nil || m == nil || c.Stats().Reloads != 1 {
+		t.Fatal(err)
+	}
+}
+
+func Test_GORMCacheLoadKeyCancel(t *testing.T) {
+	db := newTestGORMDB(t)
+	c := newTestGORMCache(t, db, 2)
+	c.TTL = time.Millisecond * 20
+	_, err := c.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(c.TTL * 2)
+	// 慢查询
+	db.Callback().Query().Before("gorm:query").Register("test:slow", func(db *gorm.DB) {
+		time.Sleep(time.Millisecond * 50)
+	})
+	// 第一个取消，等待相同 key 的不受影响
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, err := c.GetWithContext(ctx, 1)
+		errs <- err
+	}()
+	time.Sleep(time.Millisecond * 10)
+	go func() {
+		m, err := c.Get(1)
+		if err == nil && m == nil {
+			err = ErrGORMCacheNotFound
+		}
+		errs <- err
+	}()
+	time.Sleep(time.Millisecond * 10)
+	cancel()
+	if err = <-errs; err != context.Canceled {
+		t.Fatal(err)
+	}
+	if err = <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if !c.isOK() || c.Stats().ReloadErrors != 0 {
+		t.FailNow()
+	}
+}
+
+func Test_GORMCacheLoadKeyParallel(t *testing.T) {
+	db := newTestGORMDB(t)
+	c := newTestGORMCache(t, db, 2)
+	c.Max = 10
+	// 第一个查询读取后阻塞
+	var n atomic.Int32
+	block := make(chan struct{})
+	db.Callback().Query().After("gorm:query").Register("test:block", func(db *gorm.DB) {
+		if n.Add(1) == 1 {
+			<-block
+		}
+	})
+	res := make(chan *testGORMModel, 1)
+	go func() {
+		m, _ := c.Get(1)
+		res <- m
+	}()
+	for n.Load() < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	// 其他的 key 和加载不等待
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Get(2)
+		if err == nil {
+			err = db.Model(new(testGORMModel)).Where("ID = ?", 1).Update("Name", "new").Error
+		}
+		if err == nil {
+			err = c.Load(1)
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("blocked by another key")
+	}
+	// 查询期间加载过，旧的结果不保存
+	close(block)
+	if m := <-res; m == nil || m.Name != "name1" {
+		t.Fatal(m)
+	}
+	m, err := c.Get(1)
+	if err != nil || m.Name != "new" {
+		t.Fatal(m, err)
+	}
+}
+
+func Test_GORMCacheRefresh(t *testing.T) {
+	db := newTestGORMDB(t)
+	c := newTestGORMCache(t, db, 1)
+	err := c.LoadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Refresh(context.Background(), time.Millisecond*10, time.Millisecond*10, func(err error) {
+		t.Error(err)
+	})
+	defer c.Close()
+	// 其他进程修改
+	err = db.Model(new(testGORMModel)).Where("`ID` = ?", 1).Update("Name", "refresh").Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	m, err := c.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Name != "refresh" {
+		t.FailNow()
+	}
+}
+
+func testGORMCacheHas(c *GORMCache[int64, *testGORMModel], ks ...int64) bool {
+	c.RLock()
+	defer c.RUnlock()
+	if len(c.D) != len(ks) {
+		return false
+	}
+	for _, k := range ks {
+		if _, ok := c.D[k]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func Test_GORMCacheLRU(t *testing.T) {
+	db := newTestGORMDB(t)
+	c := newTestGORMCache(t, db, 10)
+	c.Max = 3
+	c.Evict = GORMCacheLRU
+	for _, k := range []int64{1, 2, 3, 1, 4} {
+		m, err := c.Get(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m == nil || m.ID != k {
+			t.FailNow()
+		}
+	}
+	if !testGORMCacheHas(c, 1, 3, 4) {
+		t.FailNow()
+	}
+	// 需要全部数据的，使用数据库
+	ms, err := c.SearchCache(func(m *testGORMModel) bool { return m.ID > 5 })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) != 5 {
+		t.FailNow()
+	}
+	n, err := c.CacheTotal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 10 {
+		t.FailNow()
+	}
+	// 没有命中的会加载
+	ms, err = c.SearchCacheIn([]int64{4, 5, 11})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) != 2 || ms[0].ID != 4 || ms[1].ID != 5 {
+		t.FailNow()
+	}
+	if !testGORMCacheHas(c, 1, 4, 5) {
+		t.FailNow()
+	}
+}
+
+func Test_GORMCacheLFU(t *testing.T) {
+	db := newTestGORMDB(t)
+	c := newTestGORMCache(t, db, 10)
+	c.Max = 3
+	c.Evict = GORMCacheLFU
+	for _, k := range []int64{1, 1, 1, 2, 2, 3, 4} {
+		_, err := c.Get(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !testGORMCacheHas(c, 1, 2, 4) {
+		t.FailNow()
+	}
+}
+
+func Test_GORMCacheNotFound(t *testing.T) {
+	db := newTestGORMDB(t)
+	c := newTestGORMCache(t, db, 1)
+	c.Cache = false
+	c.NotFoundTTL = time.Second
+	//
+	_, err := c.First(2)
+	if err != ErrGORMCacheNotFound {
+		t.FailNow()
+	}
+	m, err := c.Get(2)
+	if err != nil || m != nil {
+		t.FailNow()
+	}
+	// 其他进程添加，还在有效时间内
+	m = new(testGORMModel)
+	m.ID = 2
+	err = db.Create(m).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.First(2)
+	if err != ErrGORMCacheNotFound {
+		t.FailNow()
+	}
+	// 通过缓存添加，清除记录
+	_, err = c.First(3)
+	if err != ErrGORMCacheNotFound {
+		t.FailNow()
+	}
+	m = new(testGORMModel)
+	m.ID = 3
+	_, err = c.Add(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err = c.First(3)
+	if err != nil || m.ID != 3 {
+		t.FailNow()
+	}
+	// 有限模式
+	c.Cache = true
+	c.Max = 10
+	_, err = c.First(4)
+	if err != ErrGORMCacheNotFound {
+		t.FailNow()
+	}
+	m = new(testGORMModel)
+	m.ID = 4
+	err = db.Create(m).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.First(4)
+	if err != ErrGORMCacheNotFound {
+		t.FailNow()
+	}
+	// 全部加载模式，没有的就是不存在
+	c.Max = 0
+	_, err = c.First(5)
+	if err != ErrGORMCacheNotFound {
+		t.FailNow()
+	}
+}
+
+func testGORMCacheBus(t *testing.T, db *gorm.DB, bus1, bus2 GORMCacheBus, wait time.Duration) {
+	c1 := newTestGORMCache(t, db, 2)
+	c1.SetBus("testGORMModel", bus1)
+	defer c1.Close()
+	c2 := newTestGORMCache(t, db, 0)
+	c2.SetBus("testGORMModel", bus2)
+	defer c2.Close()
+	err := c2.LoadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 修改
+	m := new(testGORMModel)
+	m.ID = 1
+	m.Name = "bus"
+	_, err = c1.Update(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 删除
+	_, err = c1.Delete(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(wait)
+	m, err = c2.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m == nil || m.Name != "bus" {
+		t.Fatal("update not synced", m)
+	}
+	m, err = c2.Get(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m != nil {
+		t.Fatal("delete not synced")
+	}
+}
+
+func Test_GORMCacheMemoryBus(t *testing.T) {
+	bus := NewGORMCacheMemoryBus()
+	testGORMCacheBus(t, newTestGORMDB(t), bus, bus, 0)
+}
+
+func Test_GORMCacheDBBus(t *testing.T) {
+	db := newTestGORMDB(t)
+	bus1, err := NewGORMCacheDBBus(db, time.Millisecond*10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bus1.Close()
+	bus2, err := NewGORMCacheDBBus(db, time.Millisecond*10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bus2.Close()
+	testGORMCacheBus(t, db, bus1, bus2, time.Millisecond*100)
+}
+
+type testGORMSoftModel struct {
+	GORMBaseModel[int64]
+	Name      string         `gorm:""`
+	DeletedAt gorm.DeletedAt `gorm:""`
+}
+
+func Test_GORMCacheSync(t *testing.T) {
+	db := newTestGORMDB(t)
+	err := db.AutoMigrate(new(testGORMSoftModel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		m := new(testGORMSoftModel)
+		m.ID = int64(i)
+		err = db.Create(m).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := NewGORMCache(db, true,
+		func() *testGORMSoftModel { return new(testGORMSoftModel) },
+		func(m *testGORMSoftModel) int64 { return m.ID },
+		WhereID[int64],
+		WhereIDs[int64],
+	)
+	defer c.Close()
+	err = c.LoadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Sync(context.Background(), time.Millisecond*10, func(err error) {
+		t.Error(err)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 其他进程修改和软删除
+	err = db.Model(new(testGORMSoftModel)).Where("`ID` = ?", 1).Update("Name", "sync").Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Delete(new(testGORMSoftModel), 2).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	m, err := c.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m == nil || m.Name != "sync" {
+		t.FailNow()
+	}
+	m, err = c.Get(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m != nil {
+		t.FailNow()
+	}
+	// 没有 UpdatedAt
+	c2 := NewGORMCache(db, true,
+		func() *GORMCacheBusEventModel { return new(GORMCacheBusEventModel) },
+		func(m *GORMCacheBusEventModel) int64 { return m.ID },
+		WhereID[int64],
+		WhereIDs[int64],
+	)
+	if c2.Sync(context.Background(), time.Second, nil) != ErrGORMCacheNoUpdatedAt {
+		t.FailNow()
+	}
+	// UpdatedAt 不是整数
+	c3 := NewGORMCache(db, true,
+		func() *testGORMTimeModel { return new(testGORMTimeModel) },
+		func(m *testGORMTimeModel) int64 { return m.ID },
+		WhereID[int64],
+		WhereIDs[int64],
+	)
+	if c3.Sync(context.Background(), time.Second, nil) != ErrGORMCacheUpdatedAtType {
+		t.FailNow()
+	}
+}
+
+type testGORMTimeModel struct {
+	ID        int64 `gorm:"primaryKey"`
+	UpdatedAt time.Time
+}
+
+func Test_GORMCacheIndex(t *testing.T) {
+	db := newTestGORMDB(t)
+	c := newTestGORMCache(t, db, 5)
+	c.AddIndex("Phone", true, func(m *testGORMModel) any { return m.Phone })
+	c.AddIndex("Name", false, func(m *testGORMModel) any { return m.Name[:4] })
+	c.AddIndex("NamePhone", true, func(m *testGORMModel) any { return [2]any{m.Name, m.Phone} })
+	// 唯一
+	m, err := c.GetByIndex("Phone", "phone3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.ID != 3 {
+		t.FailNow()
+	}
+	// 组合
+	m, err = c.GetByIndex("NamePhone", [2]any{"name4", "phone4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.ID != 4 {
+		t.FailNow()
+	}
+	// 非唯一
+	ms, err := c.ListByIndex("Name", "name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) != 5 {
+		t.FailNow()
+	}
+	// 修改
+	m = new(testGORMModel)
+	m.ID = 3
+	m.Phone = "phone33"
+	_, err = c.Update(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.GetByIndex("Phone", "phone3")
+	if err != ErrGORMCacheNotFound {
+		t.FailNow()
+	}
+	m, err = c.GetByIndex("Phone", "phone33")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.ID != 3 {
+		t.FailNow()
+	}
+	// 内存修改
+	c.UpdateCache(3, func(m *testGORMModel) { m.Phone = "phone333" })
+	m, err = c.GetByIndex("Phone", "phone333")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.ID != 3 {
+		t.FailNow()
+	}
+	// 删除
+	_, err = c.Delete(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.GetByIndex("Phone", "phone333")
+	if err != ErrGORMCacheNotFound {
+		t.FailNow()
+	}
+	ms, err = c.ListByIndex("Name", "name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) != 4 {
+		t.FailNow()
+	}
+	// 有限模式，查询数据库
+	c.Max = 1
+	c.LoadAll()
+	m, err = c.GetByIndex("Phone", "phone5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.ID != 5 {
+		t.FailNow()
+	}
+	// 不存在的索引
+	_, err = c.GetByIndex("xx", 1)
+	if err == nil {
+		t.FailNow()
+	}
+}
+
+func Test_GORMCacheCallbackReenter(t *testing.T) {
+	db := newTestGORMDB(t)
+	c := newTestGORMCache(t, db, 3)
+	var once atomic.Bool
+	loaded := make(chan error, 1)
+	c.OnLoad = func(k int64, m *testGORMModel) {
+		// 在回调中加载
+		if once.CompareAndSwap(false, true) {
+			loaded <- c.Load(2)
+		}
+	}
+	var reloads int
+	c.OnReloadError = func(err error) {
+		reloads++
+		if reloads == 1 {
+			c.LoadAll()
+		}
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- c.LoadAll()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("deadlock")
+	}
+	if err := <-loaded; err != nil {
+		t.Fatal(err)
+	}
+	// 失败的回调中加载
+	err := db.Migrator().DropTable(new(testGORMModel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		done <- c.LoadAll()
+	}()
+	select {
+	case err := <-done:
+		if err == nil || reloads != 2 {
+			t.Fatal(err, reloads)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("deadlock")
+	}
+}
+
+func Test_GORMCacheStats(t *testing.T) {
+	db := newTestGORMDB(t)
+	c := newTestGORMCache(t, db, 3)
+	var loads, evicts int
+	var reloadErr error
+	c.OnLoad = func(k int64, m *testGORMModel) { loads++ }
+	c.OnEvict = func(k int64, m *testGORMModel) { evicts++ }
+	c.OnReloadError = func(err error) { reloadErr = err }
+	// 加载，命中和没有命中
+	c.Get(1)
+	c.Get(4)
+	_, err := c.Delete(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := c.Stats()
+	if s.Hits != 1 || s.Misses != 1 || s.Reloads != 1 || s.Count != 2 || loads != 3 || evicts != 1 {
+		t.Fatalf("%+v %d %d", s, loads, evicts)
+	}
+	// 失败
+	err = db.Migrator().DropTable(new(testGORMModel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.LoadAll() == nil || reloadErr == nil || c.Stats().ReloadErrors != 1 || c.OK {
+		t.FailNow()
+	}
+	// prometheus
+	var buf strings.Builder
+	err = WriteGORMCacheStats(&buf, map[string]GORMCacheStatser{"test": c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "gorm_cache_hits_total{cache=\"test\"} 1\n") ||
+		!strings.Contains(buf.String(), "# TYPE gorm_cache_entries gauge\n") {
+		t.Fatal(buf.String())
+	}
+	// 标签转义，只有 \ ，" 和换行
+	buf.Reset()
+	err = WriteGORMCacheStats(&buf, map[string]GORMCacheStatser{"a\\b\"c\nd\té": c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "gorm_cache_hits_total{cache=\"a\\\\b\\\"c\\nd\té\"} 1\n") {
+		t.Fatal(buf.String())
+	}
+}
+
+func Test_GORMCacheClone(t *testing.T) {
+	db := newTestGORMDB(t)
+	c := newTestGORMCache(t, db, 10)
+	c.Clone = GORMCacheDeepCopy(c.New)
+	c.AddIndex("Name", true, func(m *testGORMModel) any { return m.Name })
+	// 修改返回的数据不影响内存
+	m, err := c.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Name = "xx"
+	ms, err := c.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range ms {
+		m.Name = "xx"
+	}
+	m, err = c.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Name != "name1" {
+		t.FailNow()
+	}
+	// 更新替换副本，已经返回的不变
+	c.UpdateCache(1, func(m *testGORMModel) { m.Name = "new1" })
+	if m.Name != "name1" {
+		t.FailNow()
+	}
+	m, err = c.GetByIndex("Name", "new1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.ID != 1 {
+		t.FailNow()
+	}
+	// 并发读写，使用 -race 检查
+	var wait sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			for j := 0; j < 100; j++ {
+				k := int64(j%10 + 1)
+				if m, err := c.Get(k); err == nil && m != nil {
+					m.Name = "get"
+				}
+				ms, _ := c.SearchCache(func(m *testGORMModel) bool { return m.ID == k })
+				for _, m := range ms {
+					m.Phone = "search"
+				}
+				c.ForeachCache(func(m *testGORMModel) { m.Phone = "foreach" })
+				ms, _ = c.SearchCacheIn([]int64{k})
+				for _, m := range ms {
+					m.Name = "in"
+				}
+				c.UpdateCache(k, func(m *testGORMModel) {
+					if m != nil {
+						m.Phone = fmt.Sprintf("phone%d", i)
+					}
+				})
+				if j%20 == 0 {
+					c.LoadAll()
+				}
+			}
+		}(i)
+	}
+	wait.Wait()
+	// 内存中的数据没有被读取者修改
+	ms, err = c.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) != 10 {
+		t.FailNow()
+	}
+	for _, m := range ms {
+		if m.Name != fmt.Sprintf("name%d", m.ID) {
+			t.Fatal(m.Name)
+		}
+	}
+}
+
+func Test_GORMCacheTx(t *testing.T) {
+	db := newTestGORMDB(t)
+	c1 := newTestGORMCache(t, db, 3)
+	c2 := newTestGORMCache(t, db, 0)
+	c1.LoadAll()
+	c2.LoadAll()
+	// 回滚，内存不变
+	err := GORMTransaction(db, func(tx *GORMTx) error {
+		m := new(testGORMModel)
+		m.ID = 4
+		_, err := c1.WithTx(tx).Add(m)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("rollback")
+	})
+	if err == nil {
+		t.FailNow()
+	}
+	if !testGORMCacheHas(c1, 1, 2, 3) {
+		t.FailNow()
+	}
+	// 提交前不修改内存，提交后多个缓存都修改
+	err = GORMTransaction(db, func(tx *GORMTx) error {
+		m := new(testGORMModel)
+		m.ID = 4
+		m.Name = "name4"
+		_, err := c1.WithTx(tx).Add(m)
+		if err != nil {
+			return err
+		}
+		m = new(testGORMModel)
+		m.ID = 1
+		m.Name = "new1"
+		_, err = c2.WithTx(tx).Update(m)
+		if err != nil {
+			return err
+		}
+		_, err = c1.WithTx(tx).Delete(2)
+		if err != nil {
+			return err
+		}
+		// 嵌套回滚的丢弃
+		tx.Transaction(func(tx *GORMTx) error {
+			_, err := c1.WithTx(tx).Delete(3)
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("rollback")
+		})
+		m, _ = c2.Get(1)
+		if !testGORMCacheHas(c1, 1, 2, 3) || m.Name != "name1" {
+			return fmt.Errorf("cache changed before commit")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !testGORMCacheHas(c1, 1, 3, 4) || testGORMCacheHas(c1, 2) {
+		t.FailNow()
+	}
+	m, err := c2.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m == nil || m.Name != "new1" {
+		t.FailNow()
+	}
+}
+
+type testGORMListQuery struct {
+	Name      string  `gq:"like"`
+	Phone     *string `gq:"eq"`
+	NotName   string  `gq:"neq=Name"`
+	MinID     *int64  `gq:"lte=ID"`
+	MaxID     *int64  `gq:"gt=ID"`
+	CreatedAt *int64  `gq:"eq"`
+}
+
+func (q *testGORMListQuery) Init(db *gorm.DB) *gorm.DB {
+	return GORMInitQuery(db, q)
+}
+
+func (q *testGORMListQuery) MemoryQuery() {}
+
+// testGORMListCustomQuery 有自定义的条件，不能在内存中执行
+type testGORMListCustomQuery struct {
+	Name string `gq:"like"`
+}
+
+func (q *testGORMListCustomQuery) Init(db *gorm.DB) *gorm.DB {
+	return GORMInitQuery(db, q).Where(clause.Gt{Column: "ID", Value: 20})
+}
+
+var testGORMListOrders = map[string]string{
+	"id":        "ID",
+	"name":      "Name",
+	"phone":     "Phone",
+	"createdAt": "CreatedAt",
+	"tid":       "testGORMModel.ID",
+	"bad":       "Name; DROP TABLE testGORMModel",
+}
+
+func (q *testGORMListQuery) OrderColumns() map[string]string {
+	return testGORMListOrders
+}
+
+// testGORMListRawQuery 不检查排序
+type testGORMListRawQuery struct {
+	testGORMListQuery
+}
+
+func (q *testGORMListRawQuery) Init(db *gorm.DB) *gorm.DB {
+	return GORMInitQuery(db, q)
+}
+
+func (q *testGORMListRawQuery) RawOrder() {}
+
+func testGORMPtr[T any](v T) *T {
+	return &v
+}
+
+func Test_GORMCacheList(t *testing.T) {
+	db := newTestGORMDB(t)
+	for i := 1; i <= 30; i++ {
+		m := new(testGORMModel)
+		m.ID = int64(i)
+		m.Name = fmt.Sprintf("name%d", i%4)
+		if i%2 == 0 {
+			m.Name = fmt.Sprintf("Name_%d", i%4)
+		}
+		m.Phone = fmt.Sprintf("phone%d", i%5)
+		m.CreatedAt = int64(i % 7)
+		err := db.Create(m).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := newTestGORMCache(t, db, 0)
+	c.MemoryList = true
+	for i, p := range []struct {
+		page   *GORMListPage
+		query  GORMQuery
+		memory bool
+	}{
+		{nil, nil, true},
+		{&GORMListPage{}, &testGORMListQuery{}, true},
+		{&GORMListPage{Offset: testGORMPtr(5), Count: testGORMPtr(10), Order: "name desc"}, &testGORMListQuery{}, true},
+		{&GORMListPage{Order: "createdAt, -name"}, &testGORMListQuery{}, true},
+		{&GORMListPage{Order: "+createdAt,name DESC"}, &testGORMListQuery{}, true},
+		{&GORMListPage{Offset: testGORMPtr(100)}, nil, true},
+		{&GORMListPage{Count: testGORMPtr(0)}, nil, true},
+		{nil, &testGORMListQuery{Name: "AME1"}, true},
+		{nil, &testGORMListQuery{Name: "me_"}, true},
+		{nil, &testGORMListQuery{Phone: testGORMPtr("phone3")}, true},
+		{nil, &testGORMListQuery{NotName: "name1", MinID: testGORMPtr[int64](5), MaxID: testGORMPtr[int64](20)}, true},
+		{&GORMListPage{Count: testGORMPtr(3), Order: "-phone"}, &testGORMListQuery{CreatedAt: testGORMPtr[int64](2)}, true},
+		// 不支持
+		{&GORMListPage{Order: "-tid"}, &testGORMListQuery{}, false},
+		{nil, &testGORMListCustomQuery{Name: "AME1"}, false},
+	} {
+		// 内存
+		c.Cache = true
+		var res1 GORMListData[*testGORMModel]
+		ok, err := c.listMemory(context.Background(), p.page, p.query, &res1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != p.memory {
+			t.Fatalf("case %d memory %v", i, ok)
+		}
+		err = c.List(p.page, p.query, &res1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 数据库
+		c.Cache = false
+		var res2 GORMListData[*testGORMModel]
+		err = c.List(p.page, p.query, &res2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 比较
+		if res1.Total != res2.Total || len(res1.Data) != len(res2.Data) {
+			t.Fatalf("case %d total %d %d data %d %d", i, res1.Total, res2.Total, len(res1.Data), len(res2.Data))
+		}
+		for j := range res1.Data {
+			if *res1.Data[j] != *res2.Data[j] {
+				t.Fatalf("case %d data %d %v %v", i, j, res1.Data[j], res2.Data[j])
+			}
+		}
+	}
+	// 没有 Clone 的时候 UpdateCache 直接修改，排序不能和它并发，-race 检查
+	c.Cache = true
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			c.UpdateCache(int64(i%30+1), func(m *testGORMModel) { m.Name = fmt.Sprintf("name%d", i) })
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		var res GORMListData[*testGORMModel]
+		ok, err := c.listMemory(context.Background(), &GORMListPage{Order: "-name"}, &testGORMListQuery{}, &res)
+		if !ok || err != nil || res.Total != 30 {
+			t.Fatal(ok, err, res.Total)
+		}
+	}
+	<-done
+}
+
+func Test_GORMListOrder(t *testing.T) {
+	db := newTestGORMDB(t)
+	c := newTestGORMCache(t, db, 5)
+	c.MemoryList = true
+	g := NewGORMDB[int64](db, new(testGORMModel))
+	list := map[string]func(*GORMListPage, GORMQuery) error{
+		"db": func(p *GORMListPage, q GORMQuery) error {
+			return g.List(p, q, new(GORMListData[*testGORMModel]))
+		},
+		"cache": func(p *GORMListPage, q GORMQuery) error {
+			c.Cache = true
+			return c.List(p, q, new(GORMListData[*testGORMModel]))
+		},
+		"cache db": func(p *GORMListPage, q GORMQuery) error {
+			c.Cache = false
+			return c.List(p, q, new(GORMListData[*testGORMModel]))
+		},
+	}
+	for name, fn := range list {
+		// 不合法
+		for _, p := range []struct {
+			order string
+			query GORMQuery
+		}{
+			{"name", nil},
+			{"name", &testGORMQuery{}},
+			{"Name", &testGORMListQuery{}},
+			{"-password", &testGORMListQuery{}},
+			{"name up", &testGORMListQuery{}},
+			{"-name desc", &testGORMListQuery{}},
+			{"name desc asc", &testGORMListQuery{}},
+			{"name,", &testGORMListQuery{}},
+			{"-", &testGORMListQuery{}},
+			{"name;DROP TABLE testGORMModel", &testGORMListQuery{}},
+		} {
+			err := fn(&GORMListPage{Order: p.order}, p.query)
+			var e *GORMOrderError
+			if !errors.Is(err, ErrGORMOrder) || !errors.As(err, &e) {
+				t.Fatal(name, p.order, err)
+			}
+		}
+		// 白名单的列名不合法
+		err := fn(&GORMListPage{Order: "bad"}, &testGORMListQuery{})
+		if err == nil || errors.Is(err, ErrGORMOrder) {
+			t.Fatal(name, err)
+		}
+		// 数据还在
+		err = fn(&GORMListPage{Order: "-id"}, &testGORMListQuery{})
+		if err != nil {
+			t.Fatal(name, err)
+		}
+	}
+	// Init 原样排序，InitOrder 检查
+	var ms []*testGORMModel
+	err := (&GORMListPage{Order: "ID desc"}).Init(db.Model(new(testGORMModel))).Find(&ms).Error
+	if err != nil || len(ms) != 5 || ms[0].ID != 5 {
+		t.Fatal(err)
+	}
+	err = (&GORMListPage{Order: "name"}).InitOrder(db.Model(new(testGORMModel)), nil).Find(&ms).Error
+	if !errors.Is(err, ErrGORMOrder) {
+		t.Fatal(err)
+	}
+	ms = nil
+	err = (&GORMListPage{Order: "-id"}).InitOrder(db.Model(new(testGORMModel)), testGORMListOrders).Find(&ms).Error
+	if err != nil || len(ms) != 5 || ms[0].ID != 5 {
+		t.Fatal(err)
+	}
+	// GORMRawOrderQuery 原样排序
+	for name, fn := range list {
+		err = fn(&GORMListPage{Order: "ID desc"}, &testGORMListQuery{})
+		if !errors.Is(err, ErrGORMOrder) {
+			t.Fatal(name, err)
+		}
+		var res GORMListData[*testGORMModel]
+		if name == "db" {
+			err = g.List(&GORMListPage{Order: "ID desc"}, &testGORMListRawQuery{}, &res)
+		} else {
+			c.Cache = name == "cache"
+			err = c.List(&GORMListPage{Order: "ID desc"}, &testGORMListRawQuery{}, &res)
+		}
+		if err != nil || len(res.Data) != 5 || res.Data[0].ID != 5 {
+			t.Fatal(name, err)
+		}
+	}
+}
+
+func Test_GORMCacheWriteBehind(t *testing.T) {
+	db := newTestGORMDB(t)
+	c := newTestGORMCache(t, db, 3)
+	var fail, retry bool
+	var failed []int64
+	db.Callback().Update().Before("gorm:update").Register("test:fail", func(db *gorm.DB) {
+		if fail {
+			db.AddError(fmt.Errorf("fail"))
+		}
+	})
+	c.WriteBehind(context.Background(), time.Hour, 2, true, func(ks []int64, err error) bool {
+		failed = ks
+		return retry
+	})
+	name := func(k int64) string {
+		m := new(testGORMModel)
+		db.Where("ID = ?", k).First(m)
+		return m.Name
+	}
+	// 不存在
+	if c.UpdateBehind(4, func(m *testGORMModel) {}) != ErrGORMCacheNotFound {
+		t.FailNow()
+	}
+	// 只修改内存
+	old, _ := c.Get(1)
+	for i := 0; i < 3; i++ {
+		err := c.UpdateBehind(1, func(m *testGORMModel) { m.Name = fmt.Sprintf("new%d", i) })
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	m, _ := c.Get(1)
+	if m.Name != "new2" || old.Name != "name1" || name(1) != "name1" {
+		t.FailNow()
+	}
+	// 全部加载不会覆盖
+	c.LoadAll()
+	m, _ = c.Get(1)
+	if m.Name != "new2" {
+		t.FailNow()
+	}
+	// 达到数量立即写入
+	c.UpdateBehind(2, func(m *testGORMModel) { m.Name = "new" })
+	for i := 0; name(1) != "new2" || name(2) != "new"; i++ {
+		if i > 100 {
+			t.FailNow()
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	// 失败重试
+	fail, retry = true, true
+	c.UpdateBehind(3, func(m *testGORMModel) { m.Name = "new" })
+	if c.Flush() == nil || len(failed) != 1 || failed[0] != 3 {
+		t.FailNow()
+	}
+	fail = false
+	if c.Flush() != nil || name(3) != "new" {
+		t.FailNow()
+	}
+	// 失败丢弃，重新加载
+	fail, retry = true, false
+	c.UpdateBehind(3, func(m *testGORMModel) { m.Name = "drop" })
+	if c.Flush() == nil {
+		t.FailNow()
+	}
+	fail = false
+	m, _ = c.Get(3)
+	if m.Name != "new" {
+		t.FailNow()
+	}
+	// 关闭的时候写入
+	c.UpdateBehind(1, func(m *testGORMModel) { m.Name = "close" })
+	if c.Close() != nil || name(1) != "close" {
+		t.FailNow()
+	}
+}
+
+func Test_GORMCacheSnapshot(t *testing.T) {
+	db := newTestGORMDB(t)
+	c := newTestGORMCache(t, db, 5)
+	name := filepath.Join(t.TempDir(), "snapshot")
+	err := c.DumpFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 修改数据库
+	err = db.Model(new(testGORMModel)).Where("ID = ?", 1).Updates(map[string]any{"Name": "new1", "UpdatedAt": 1}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Delete(new(testGORMModel), 2).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Create(&testGORMModel{GORMBaseModel: GORMBaseModel[int64]{ID: 6}}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(c *GORMCache[int64, *testGORMModel]) {
+		if !testGORMCacheHas(c, 1, 3, 4, 5, 6) {
+			t.FailNow()
+		}
+		m, _ := c.Get(1)
+		if m.Name != "new1" {
+			t.FailNow()
+		}
+	}
+	// 恢复后使用旧的数据，比较后和数据库一致
+	c = newTestGORMCache(t, db, 0)
+	err = c.RestoreFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, _ := c.Get(1)
+	if !testGORMCacheHas(c, 1, 2, 3, 4, 5) || m.Name != "name1" {
+		t.FailNow()
+	}
+	err = c.Reconcile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(c)
+	// 后台比较
+	c = newTestGORMCache(t, db, 0)
+	if c.WarmUp(context.Background(), name+"x", nil) == nil {
+		t.FailNow()
+	}
+	err = c.WarmUp(context.Background(), name, func(err error) { t.Error(err) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	check(c)
+}
+
+// testGORMLockWriter 在写入的时候检查 c 没有上锁
+type testGORMLockWriter struct {
+	c      *GORMCache[int64, *testGORMModel]
+	locked bool
+}
+
+func (w *testGORMLockWriter) Write(p []byte) (int, error) {
+	if !w.c.TryLock() {
+		w.locked = true
+	} else {
+		w.c.Unlock()
+	}
+	return len(p), nil
+}
+
+// testGORMReconcileModel 是值类型，不能比较
+type testGORMReconcileModel struct {
+	A         int64    `gorm:"primaryKey"`
+	B         string   `gorm:"primaryKey;type:varchar(32)"`
+	UpdatedAt int64    `gorm:""`
+	Tags      []string `gorm:"-"`
+}
+
+type testGORMNoKeyModel struct {
+	Name      string
+	UpdatedAt int64
+}
+
+func Test_GORMCacheReconcile(t *testing.T) {
+	db := newTestGORMDB(t)
+	c := newTestGORMCache(t, db, 3)
+	// 写入的时候没有上锁
+	w := &testGORMLockWriter{c: c}
+	err := c.Dump(w)
+	if err != nil || w.locked {
+		t.Fatal(err, w.locked)
+	}
+	// 没有 Clone 的时候 UpdateCache 直接修改，编码不能和它并发，-race 检查
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			c.UpdateCache(1, func(m *testGORMModel) { m.Name = fmt.Sprintf("dump%d", i) })
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		err = c.Dump(io.Discard)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+	// 复合主键，值类型
+	err = db.AutoMigrate(new(testGORMReconcileModel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(1); i <= 3; i++ {
+		err = db.Create(&testGORMReconcileModel{A: i, B: "x"}).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	c2 := NewGORMCache(db, true,
+		func() testGORMReconcileModel { return testGORMReconcileModel{} },
+		func(m testGORMReconcileModel) testGORMLinkKey { return testGORMLinkKey{A: m.A, B: m.B} },
+		WhereStructKey[testGORMLinkKey],
+		WhereStructKeys[testGORMLinkKey],
+	)
+	err = c2.LoadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Where("A = ?", 2).Delete(new(testGORMReconcileModel)).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Model(new(testGORMReconcileModel)).Where("A = ?", 3).Update("UpdatedAt", 1).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c2.Reconcile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms, _ := c2.All()
+	if len(ms) != 2 {
+		t.Fatal(ms)
+	}
+	m, _ := c2.Get(testGORMLinkKey{A: 3, B: "x"})
+	if m.UpdatedAt != 1 {
+		t.Fatal(m)
+	}
+	// 没有主键
+	c3 := NewGORMCache(db, true,
+		func() *testGORMNoKeyModel { return new(testGORMNoKeyModel) },
+		func(m *testGORMNoKeyModel) string { return m.Name },
+		nil,
+		nil,
+	)
+	if c3.Reconcile() != ErrGORMCacheNoPrimaryKey {
+		t.FailNow()
+	}
+}
+
+func testGORMCacheUnlocked[K comparable, M any](t *testing.T, c *GORMCache[K, M]) {
+	if !c.TryLock() {
+		t.Fatal("locked")
+	}
+	c.Unlock()
+}
+
+func Test_GORMCacheEvict(t *testing.T) {
+	db := newTestGORMDB(t)
+	c := newTestGORMCache(t, db, 10)
+	c.LoadAll()
+	// 删除第一个
+	c.DeleteCacheWhere(func(m *testGORMModel) bool { return m.ID > 8 })
+	testGORMCacheUnlocked(t, c)
+	if !testGORMCacheHas(c, 1, 2, 3, 4, 5, 6, 7, 8, 9) && !testGORMCacheHas(c, 1, 2, 3, 4, 5, 6, 7, 8, 10) {
+		t.FailNow()
+	}
+	c.DeleteCacheWhere(func(m *testGORMModel) bool { return false })
+	testGORMCacheUnlocked(t, c)
+	// 删除所有匹配的
+	d := c.EvictCacheWhere(func(m *testGORMModel) bool { return m.ID > 7 })
+	testGORMCacheUnlocked(t, c)
+	if len(d) != 2 || !testGORMCacheHas(c, 1, 2, 3, 4, 5, 6, 7) {
+		t.FailNow()
+	}
+	// 范围
+	d = GORMEvictCacheRange(c, 3, 6)
+	testGORMCacheUnlocked(t, c)
+	if len(d) != 3 || d[3] == nil || d[4] == nil || d[5] == nil || !testGORMCacheHas(c, 1, 2, 6, 7) {
+		t.FailNow()
+	}
+	// 前缀
+	s := NewGORMCache(db, true,
+		func() *testGORMModel { return new(testGORMModel) },
+		func(m *testGORMModel) string { return m.Name },
+		nil, nil,
+	)
+	s.LoadAll()
+	d2 := GORMEvictCachePrefix(s, "name1")
+	testGORMCacheUnlocked(t, s)
+	if len(d2) != 2 || d2["name1"] == nil || d2["name10"] == nil || len(s.D) != 8 {
+		t.FailNow()
+	}
+	// 全部，下一次读取重新加载
+	c.InvalidateAll()
+	testGORMCacheUnlocked(t, c)
+	if c.OK || len(c.D) != 0 {
+		t.FailNow()
+	}
+	m, err := c.Get(9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m == nil || !c.OK || len(c.D) != 10 {
+		t.FailNow()
+	}
+}
+
+func Test_GORMCacheAuto(t *testing.T) {
+	db := newTestGORMDB(t)
+	newTestGORMCache(t, db, 5)
+	// 单个主键
+	c, err := NewGORMCacheAuto[int64, *testGORMModel](db, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := c.Get(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m == nil || m.Name != "name3" {
+		t.FailNow()
+	}
+	n, err := c.BatchDelete([]int64{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || !testGORMCacheHas(c, 3, 4, 5) {
+		t.FailNow()
+	}
+	_, err = NewGORMCacheAuto[string, *testGORMModel](db, true)
+	if err == nil {
+		t.FailNow()
+	}
+	_, err = NewGORMCacheAuto[int64, testGORMModel](db, true)
+	if err == nil {
+		t.FailNow()
+	}
+	// 复合主键
+	_, c2 := newTestGORMLinkCache(t)
+	c3, err := NewGORMCacheAuto[testGORMLinkKey, *testGORMLinkModel](c2.DB, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m2, err := c3.Get(testGORMLinkKey{A: 3, B: "y"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m2 == nil || m2.Name != "3y" {
+		t.FailNow()
+	}
+	n, err = c3.BatchDelete([]testGORMLinkKey{{1, "x"}, {3, "y"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || len(c3.D) != 4 {
+		t.FailNow()
+	}
+	_, err = NewGORMCacheAuto[int64, *testGORMLinkModel](c2.DB, true)
+	if err == nil {
+		t.FailNow()
+	}
+}
+
+type testGORMTenantModel struct {
+	ID       int64 `gorm:"primaryKey"`
+	TenantID int64 `gorm:"index"`
+	Name     string
+}
+
+func Test_GORMPartitionCache(t *testing.T) {
+	db := newTestGORMDB(t)
+	err := db.AutoMigrate(new(testGORMTenantModel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 10; i++ {
+		err = db.Create(&testGORMTenantModel{ID: int64(i), TenantID: int64(i % 2), Name: fmt.Sprintf("name%d", i)}).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	var refresh atomic.Bool
+	c := NewGORMPartitionCache[int64]("TenantID", func() *GORMCache[int64, *testGORMTenantModel] {
+		c, err := NewGORMCacheAuto[int64, *testGORMTenantModel](db, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}, func(p int64, c *GORMCache[int64, *testGORMTenantModel]) {
+		// 协程读取的是分区的 Scope ，只有第一个分区 1
+		if p == 1 && refresh.CompareAndSwap(false, true) {
+			c.Refresh(context.Background(), time.Millisecond, 0, func(err error) {
+				t.Error(err)
+			})
+		}
+	})
+	// 只加载一个分区
+	ms, err := c.Partition(1).All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) != 5 {
+		t.FailNow()
+	}
+	for _, m := range ms {
+		if m.TenantID != 1 {
+			t.FailNow()
+		}
+	}
+	if c.Partition(0).OK || len(c.Partitions()) != 2 {
+		t.FailNow()
+	}
+	// 其他分区的数据
+	m, err := c.Partition(1).Get(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m != nil {
+		t.FailNow()
+	}
+	err = c.Partition(1).LoadWhere(func(db *gorm.DB) *gorm.DB {
+		return db.Where("ID IN ?", []int64{2, 3})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := func(p int64) int {
+		pc := c.Partition(p)
+		pc.RLock()
+		defer pc.RUnlock()
+		return len(pc.D)
+	}
+	if size(1) != 5 {
+		t.FailNow()
+	}
+	// 单独加载
+	err = db.Model(new(testGORMTenantModel)).Where("ID = ?", 2).Update("Name", "new2").Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Reload(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, _ = c.Partition(0).Get(2)
+	if m == nil || m.Name != "new2" {
+		t.FailNow()
+	}
+	// 刷新的也只有这个分区的数据
+	time.Sleep(time.Millisecond * 20)
+	if n := size(1); n != 5 {
+		t.Fatal(n)
+	}
+	// 删除
+	p := c.Partition(1)
+	err = c.Evict(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Partition(1) == p || c.Partition(1).OK {
+		t.FailNow()
+	}
+	if c.Close() != nil || len(c.Partitions()) != 0 {
+		t.FailNow()
+	}
+}
+
+func testGORMCacheRecv(t *testing.T, s *GORMCacheSubscription[int64, *testGORMModel], op GORMCacheOp, k int64) *GORMCacheChange[int64, *testGORMModel] {
+	select {
+	case e := <-s.C:
+		if e.Op != op || e.Key != k {
+			t.Fatalf("%d %d", e.Op, e.Key)
+		}
+		return e
+	default:
+		t.Fatalf("no change %d %d", op, k)
+	}
+	return nil
+}
+
+func Test_GORMCacheSubscribe(t *testing.T) {
+	db := newTestGORMDB(t)
+	c := newTestGORMCache(t, db, 3)
+	s := c.Subscribe(10)
+	// 加载
+	err := c.LoadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		e := <-s.C
+		if e.Op != GORMCacheOpAdd || e.Old != nil || e.New == nil || e.New.ID != e.Key {
+			t.FailNow()
+		}
+	}
+	// 数据一样不通知
+	err = c.LoadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.C) != 0 {
+		t.FailNow()
+	}
+	// 修改
+	m := new(testGORMModel)
+	m.ID = 1
+	m.Name = "new1"
+	_, err = c.Update(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := testGORMCacheRecv(t, s, GORMCacheOpUpdate, 1)
+	if e.Old.Name != "name1" || e.New.Name != "new1" {
+		t.FailNow()
+	}
+	// 删除
+	_, err = c.Delete(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e = testGORMCacheRecv(t, s, GORMCacheOpDelete, 2)
+	if e.Old.Name != "name2" || e.New != nil {
+		t.FailNow()
+	}
+	// 全部加载，数据库中没有的删除
+	err = db.Delete(new(testGORMModel), 3).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.LoadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testGORMCacheRecv(t, s, GORMCacheOpDelete, 3)
+	// 淘汰
+	c.InvalidateAll()
+	testGORMCacheRecv(t, s, GORMCacheOpEvict, 1)
+	// 丢弃
+	s2 := c.Subscribe(1)
+	err = c.LoadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testGORMCacheRecv(t, s, GORMCacheOpAdd, 1)
+	testGORMCacheRecv(t, s2, GORMCacheOpAdd, 1)
+	c.UpdateCache(1, func(m *testGORMModel) {})
+	c.UpdateCache(1, func(m *testGORMModel) {})
+	if s2.Dropped() != 1 || s.Dropped() != 0 {
+		t.FailNow()
+	}
+	// 取消
+	s2.Close()
+	c.UpdateCache(1, func(m *testGORMModel) {})
+	if len(s.C) != 3 {
+		t.FailNow()
+	}
+	err = c.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range s.C {
+	}
+	for range s2.C {
+	}
+}
+
+type testGORMOrderModel struct {
+	ID     int64          `gorm:"primaryKey"`
+	UserID int64          `gorm:""`
+	User   *testGORMModel `gorm:"foreignKey:UserID"`
+}
+
+func testGORMCacheWait(fn func() bool) bool {
+	for i := 0; i < 100; i++ {
+		if fn() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func Test_GORMCacheRefer(t *testing.T) {
+	db := newTestGORMDB(t)
+	err := db.AutoMigrate(new(testGORMOrderModel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := newTestGORMCache(t, db, 2)
+	for i := int64(1); i <= 3; i++ {
+		err = db.Create(&testGORMOrderModel{ID: i, UserID: (i + 1) / 2}).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Preload
+	orders1 := NewGORMCache(db, true,
+		func() *testGORMOrderModel { return new(testGORMOrderModel) },
+		func(m *testGORMOrderModel) int64 { return m.ID },
+		WhereID[int64],
+		WhereIDs[int64],
+	)
+	orders1.Preload = []string{"User"}
+	// Joins ，主键的列带上表名
+	orders2, err := NewGORMCacheAuto[int64, *testGORMOrderModel](db, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orders2.Joins = []string{"User"}
+	for _, c := range []*GORMCache[int64, *testGORMOrderModel]{orders1, orders2} {
+		m, err := c.Get(3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.User == nil || m.User.Name != "name2" {
+			t.FailNow()
+		}
+		m, err = c.First(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.User == nil || m.User.Name != "name1" {
+			t.FailNow()
+		}
+		c := c
+		GORMCacheRefer(c, users, 10, func(m *testGORMOrderModel, e *GORMCacheChange[int64, *testGORMModel]) bool {
+			// 在锁外回调，可以修改 a
+			c.UpdateCache(m.ID, func(*testGORMOrderModel) {})
+			return m.UserID == e.Key
+		})
+	}
+	err = users.LoadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 修改关联
+	u := new(testGORMModel)
+	u.ID = 1
+	u.Name = "new1"
+	_, err = users.Update(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []*GORMCache[int64, *testGORMOrderModel]{orders1, orders2} {
+		if !testGORMCacheWait(func() bool {
+			m1, _ := c.Get(1)
+			m2, _ := c.Get(2)
+			return m1.User.Name == "new1" && m2.User.Name == "new1"
+		}) {
+			t.FailNow()
+		}
+		m, _ := c.Get(3)
+		if m.User.Name != "name2" {
+			t.FailNow()
+		}
+	}
+	// 数据库分页也有关联
+	var res GORMListData[*testGORMOrderModel]
+	err = orders2.List(&GORMListPage{}, nil, &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Total != 3 || len(res.Data) != 3 || res.Data[0].User == nil {
+		t.FailNow()
+	}
+	// 退出
+	if orders1.Close() != nil || orders2.Close() != nil || users.Close() != nil {
+		t.FailNow()
+	}
+}
@@ -0,0 +1,143 @@
+package util
+
+import (
+	"context"
+)
+
+// GORMCacheTx 是 GORMCache 在事务中的写操作，
+// 使用事务写数据库，修改内存和发布事件等到事务提交后执行，回滚则丢弃。
+// 多个 GORMCache 可以使用同一个事务。
+// 设置了 Version 的，冲突返回 GORMConflictError ，事务回滚后 m 的版本不会还原
+type GORMCacheTx[K comparable, M any] struct {
+	c  *GORMCache[K, M]
+	tx *GORMTx
+}
+
+// WithTx 返回在 tx 中写数据库的 GORMCacheTx
+func (c *GORMCache[K, M]) WithTx(tx *GORMTx) *GORMCacheTx[K, M] {
+	return &GORMCacheTx[K, M]{
+		c:  c,
+		tx: tx,
+	}
+}
+
+// context 返回事务的 context ，用于提交后加载
+func (t *GORMCacheTx[K, M]) context() context.Context {
+	if t.tx.DB.Statement.Context != nil {
+		return t.tx.DB.Statement.Context
+	}
+	return context.Background()
+}
+
+// afterChanged 事务提交后重新加载 ks
+func (t *GORMCacheTx[K, M]) afterChanged(ks []K) {
+	ctx := t.context()
+	t.tx.AfterCommit(func() {
+		t.c.changed(ctx, ks)
+	})
+}
+
+// afterDeleted 事务提交后删除 ks
+func (t *GORMCacheTx[K, M]) afterDeleted(ks []K) {
+	t.tx.AfterCommit(func() {
+		t.c.deleted(ks)
+	})
+}
+
+// Add 添加
+func (t *GORMCacheTx[K, M]) Add(m M) (int64, error) {
+	// 数据库
+	db := t.tx.DB.Model(m).Create(m)
+	if db.Error == nil && db.RowsAffected > 0 {
+		// 内存
+		t.afterChanged([]K{t.c.Key(m)})
+	}
+	//
+	return db.RowsAffected, db.Error
+}
+
+// Update 更新
+func (t *GORMCacheTx[K, M]) Update(m M) (int64, error) {
+	// 数据库
+	k := t.c.Key(m)
+	db, _ := t.c.write(t.c.WhereKey(t.c.scoped(t.tx.DB.Model(m)), k), m, false)
+	if db.Error == nil && db.RowsAffected > 0 {
+		// 内存
+		t.afterChanged([]K{k})
+	}
+	//
+	return db.RowsAffected, db.Error
+}
+
+// BatchUpdate 批量更新
+func (t *GORMCacheTx[K, M]) BatchUpdate(ms []M) (int64, error) {
+	var ks []K
+	// 数据库
+	for _, m := range ms {
+		k := t.c.Key(m)
+		db, _ := t.c.write(t.c.WhereKey(t.c.scoped(t.tx.DB.Model(m)), k), m, false)
+		if db.Error != nil {
+			return 0, db.Error
+		}
+		ks = append(ks, k)
+	}
+	// 内存
+	t.afterChanged(ks)
+	//
+	return int64(len(ms)), nil
+}
+
+// Save 保存
+func (t *GORMCacheTx[K, M]) Save(m M) (int64, error) {
+	// 数据库
+	k := t.c.Key(m)
+	db, _ := t.c.write(t.c.WhereKey(t.c.scoped(t.tx.DB.Model(m)), k), m, true)
+	if db.Error == nil && db.RowsAffected > 0 {
+		// 内存
+		t.afterChanged([]K{k})
+	}
+	//
+	return db.RowsAffected, db.Error
+}
+
+// BatchSave 批量保存
+func (t *GORMCacheTx[K, M]) BatchSave(ms []M) (int64, error) {
+	var ks []K
+	// 数据库
+	for _, m := range ms {
+		k := t.c.Key(m)
+		db, _ := t.c.write(t.c.WhereKey(t.c.scoped(t.tx.DB.Model(m)), k), m, true)
+		if db.Error != nil {
+			return 0, db.Error
+		}
+		ks = append(ks, k)
+	}
+	// 内存
+	t.afterChanged(ks)
+	//
+	return int64(len(ms)), nil
+}
+
+// Delete 删除
+func (t *GORMCacheTx[K, M]) Delete(k K) (int64, error) {
+	// 数据库
+	db := t.c.WhereKey(t.c.scoped(t.tx.DB.Model(t.c.M)), k).Delete(t.c.M)
+	if db.Error == nil && db.RowsAffected > 0 {
+		// 内存
+		t.afterDeleted([]K{k})
+	}
+	//
+	return db.RowsAffected, db.Error
+}
+
+// BatchDelete 批量删除
+func (t *GORMCacheTx[K, M]) BatchDelete(ks []K) (int64, error) {
+	// 数据库
+	db := t.c.WhereKeys(t.c.scoped(t.tx.DB.Model(t.c.M)), ks).Delete(t.c.M)
+	if db.Error == nil && db.RowsAffected > 0 {
+		// 内存
+		t.afterDeleted(ks)
+	}
+	//
+	return db.RowsAffected, db.Error
+}
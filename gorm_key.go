@@ -0,0 +1,147 @@
+package util
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+var (
+	// gormStructKeys 缓存 K 的字段，reflect.Type -> *gormStructKey
+	gormStructKeys sync.Map
+)
+
+// gormStructKey 是复合主键结构的字段
+type gormStructKey struct {
+	// 字段的下标
+	index []int
+	// 字段的 tag 指定的列名，没有是空字符串，使用 NamingStrategy
+	column []string
+	// 字段的名称
+	name []string
+}
+
+// getGORMStructKey 返回 t 的字段，t 必须是结构
+func getGORMStructKey(t reflect.Type) *gormStructKey {
+	if v, ok := gormStructKeys.Load(t); ok {
+		return v.(*gormStructKey)
+	}
+	if t.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("key %s must be struct", t))
+	}
+	k := new(gormStructKey)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := schema.ParseTagSetting(f.Tag.Get("gorm"), ";")
+		if _, ok := tag["-"]; ok {
+			continue
+		}
+		k.index = append(k.index, i)
+		k.column = append(k.column, tag["COLUMN"])
+		k.name = append(k.name, f.Name)
+	}
+	gormStructKeys.Store(t, k)
+	return k
+}
+
+// columns 返回列名
+func (k *gormStructKey) columns(db *gorm.DB) []string {
+	cs := make([]string, len(k.index))
+	for i := range k.index {
+		cs[i] = k.column[i]
+		if cs[i] == "" {
+			cs[i] = db.NamingStrategy.ColumnName("", k.name[i])
+		}
+	}
+	return cs
+}
+
+// values 返回 v 的字段值
+func (k *gormStructKey) values(v reflect.Value) []any {
+	vs := make([]any, len(k.index))
+	for i, j := range k.index {
+		vs[i] = v.Field(j).Interface()
+	}
+	return vs
+}
+
+// isGORMStructKey 返回 K 是否复合主键结构
+func isGORMStructKey[K any]() bool {
+	var k K
+	return reflect.TypeOf(&k).Elem().Kind() == reflect.Struct
+}
+
+// WhereStructKey 初始化 GORMCache 需要的函数，用于复合主键，
+// K 是结构，字段对应主键的列，列名可以使用 gorm:"column:xx" 指定，
+// 没有指定使用 NamingStrategy 转换字段名
+func WhereStructKey[K any](db *gorm.DB, k K) *gorm.DB {
+	v := reflect.ValueOf(k)
+	sk := getGORMStructKey(v.Type())
+	return gormWhereKey(db, sk.columns(db), sk.values(v))
+}
+
+// WhereStructKeys 初始化 GORMCache 需要的函数，用于复合主键，K 和 WhereStructKey 一样。
+// mysql 和 postgres 使用 (a, b) IN ((?, ?), ...) ，其他的比如 sqlite 使用 OR 展开
+func WhereStructKeys[K any](db *gorm.DB, ks []K) *gorm.DB {
+	sk := getGORMStructKey(reflect.TypeOf(ks).Elem())
+	vs := make([][]any, len(ks))
+	for i, k := range ks {
+		vs[i] = sk.values(reflect.ValueOf(k))
+	}
+	return gormWhereKeys(db, sk.columns(db), vs)
+}
+
+// gormWhereKey 返回 cs 列等于 vs 的条件
+func gormWhereKey(db *gorm.DB, cs []string, vs []any) *gorm.DB {
+	exprs := make([]clause.Expression, len(cs))
+	for i, c := range cs {
+		exprs[i] = clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: c}, Value: vs[i]}
+	}
+	return db.Where(clause.And(exprs...))
+}
+
+// gormWhereKeys 返回 cs 列在 vs 中的条件，vs 的每一项和 cs 对应
+func gormWhereKeys(db *gorm.DB, cs []string, vs [][]any) *gorm.DB {
+	if len(vs) < 1 {
+		return db.Where(clause.Expr{SQL: "1 = 0"})
+	}
+	// 行值
+	switch db.Dialector.Name() {
+	case "mysql", "postgres":
+		// 列在生成语句的时候才有表名
+		var str strings.Builder
+		str.WriteByte('(')
+		vars := make([]any, 0, len(cs)+1)
+		for i, c := range cs {
+			if i > 0 {
+				str.WriteByte(',')
+			}
+			str.WriteByte('?')
+			vars = append(vars, clause.Column{Table: clause.CurrentTable, Name: c})
+		}
+		str.WriteString(") IN ?")
+		return db.Where(clause.Expr{SQL: str.String(), Vars: append(vars, vs)})
+	}
+	// OR 展开
+	ors := make([]clause.Expression, len(vs))
+	for i, v := range vs {
+		exprs := make([]clause.Expression, len(cs))
+		for j, c := range cs {
+			exprs[j] = clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: c}, Value: v[j]}
+		}
+		ors[i] = clause.And(exprs...)
+	}
+	// 只有一个的 OR 会和前面的条件 OR 连接
+	if len(ors) == 1 {
+		return db.Where(ors[0])
+	}
+	return db.Where(clause.Or(ors...))
+}
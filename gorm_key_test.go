@@ -0,0 +1,124 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	gormmysql "gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+type testGORMLinkModel struct {
+	A    int64  `gorm:"primaryKey"`
+	B    string `gorm:"primaryKey;type:varchar(32)"`
+	Name string
+}
+
+type testGORMLinkKey struct {
+	A int64
+	B string `gorm:"column:B"`
+}
+
+func newTestGORMLinkCache(t *testing.T) (*gorm.DB, *GORMCache[testGORMLinkKey, *testGORMLinkModel]) {
+	db := newTestGORMDB(t)
+	err := db.AutoMigrate(new(testGORMLinkModel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		for _, b := range []string{"x", "y"} {
+			err = db.Create(&testGORMLinkModel{A: int64(i), B: b, Name: fmt.Sprintf("%d%s", i, b)}).Error
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return db, NewGORMCache(db, true,
+		func() *testGORMLinkModel { return new(testGORMLinkModel) },
+		func(m *testGORMLinkModel) testGORMLinkKey { return testGORMLinkKey{A: m.A, B: m.B} },
+		WhereStructKey[testGORMLinkKey],
+		WhereStructKeys[testGORMLinkKey],
+	)
+}
+
+func Test_GORMStructKey(t *testing.T) {
+	db, c := newTestGORMLinkCache(t)
+	// 缓存
+	m, err := c.Get(testGORMLinkKey{A: 2, B: "y"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m == nil || m.Name != "2y" {
+		t.FailNow()
+	}
+	_, err = c.Update(&testGORMLinkModel{A: 2, B: "y", Name: "new"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, _ = c.Get(testGORMLinkKey{A: 2, B: "y"})
+	if m.Name != "new" {
+		t.FailNow()
+	}
+	n, err := c.BatchDelete([]testGORMLinkKey{{1, "x"}, {2, "y"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || len(c.D) != 4 {
+		t.FailNow()
+	}
+	// GORMDB
+	g := NewGORMDB[testGORMLinkKey](db, new(testGORMLinkModel))
+	ms, err := g.In([]testGORMLinkKey{{1, "y"}, {3, "x"}, {3, "z"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) != 2 {
+		t.FailNow()
+	}
+	ms, err = g.In([]testGORMLinkKey{{1, "y"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) != 1 || ms[0].Name != "1y" {
+		t.FailNow()
+	}
+	n, err = g.Delete(testGORMLinkKey{A: 1, B: "y"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.FailNow()
+	}
+	n, err = g.BatchDelete([]testGORMLinkKey{{3, "x"}, {3, "y"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.FailNow()
+	}
+	// 空的不匹配
+	n, err = g.BatchDelete(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.FailNow()
+	}
+}
+
+func Test_GORMStructKeyMysql(t *testing.T) {
+	db, err := gorm.Open(gormmysql.New(gormmysql.Config{
+		DSN:                       "root@tcp(127.0.0.1:3306)/test",
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{DryRun: true, DisableAutomaticPing: true, NamingStrategy: NewGORMConfig().NamingStrategy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ms []*testGORMLinkModel
+	db = WhereStructKeys(db.Model(new(testGORMLinkModel)), []testGORMLinkKey{{1, "x"}, {2, "y"}}).Find(&ms)
+	sql := db.Statement.SQL.String()
+	if !strings.Contains(sql, "WHERE (`testGORMLinkModel`.`A`,`testGORMLinkModel`.`B`) IN ((?,?),(?,?))") || len(db.Statement.Vars) != 4 {
+		t.Fatal(sql)
+	}
+}
@@ -0,0 +1,130 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrGORMOrder 表示 GORMListPage.Order 不合法，
+	// 可以使用 errors.Is 判断 GORMOrderError ，gin 的 handler 可以返回 400
+	ErrGORMOrder = errors.New("gorm order not allowed")
+)
+
+// GORMOrderError 是 GORMListPage.Order 不合法的错误，
+// 列不在白名单中，或者方向不是 asc 和 desc
+type GORMOrderError struct {
+	// 不合法的那一项，比如 "-Password"
+	Order string
+}
+
+// Error 实现 error
+func (e *GORMOrderError) Error() string {
+	return fmt.Sprintf("gorm order %q not allowed", e.Order)
+}
+
+// Is 用于 errors.Is(err, ErrGORMOrder)
+func (e *GORMOrderError) Is(target error) bool {
+	return target == ErrGORMOrder
+}
+
+// GORMOrderQuery 是可以排序的查询参数，GORMList 使用 OrderColumns 作为白名单，
+// 没有实现的查询参数不能排序
+type GORMOrderQuery interface {
+	GORMQuery
+	// 返回允许排序的键和对应的列名，比如 {"name": "Name", "createdAt": "CreatedAt"} ，
+	// 列名可以带上表名，比如 "User.Name" 。每次调用都会使用，最好返回同一个 map
+	OrderColumns() map[string]string
+}
+
+// GORMRawOrderQuery 是不检查排序的查询参数，GORMList 把 GORMListPage.Order 原样传给 db.Order ，
+// 和 GORMOrderQuery 之前的版本一样。用于还没有白名单的代码，
+// Order 来自请求的时候有 SQL 注入的风险，新的代码使用 GORMOrderQuery
+type GORMRawOrderQuery interface {
+	GORMQuery
+	// 只是标记
+	RawOrder()
+}
+
+// gormListOrders 返回 GORMList 的排序，query 实现了 GORMRawOrderQuery 原样使用，
+// 否则使用 GORMOrderQuery 的白名单，没有实现的不能排序
+func gormListOrders(page *GORMListPage, query GORMQuery) ([]clause.OrderByColumn, error) {
+	if _, ok := query.(GORMRawOrderQuery); ok {
+		return page.rawOrder(), nil
+	}
+	var columns map[string]string
+	if q, ok := query.(GORMOrderQuery); ok {
+		columns = q.OrderColumns()
+	}
+	return page.parseOrder(columns)
+}
+
+// rawOrder 返回原样的 m.Order ，和 db.Order(m.Order) 一样
+func (m *GORMListPage) rawOrder() []clause.OrderByColumn {
+	if m == nil || m.Order == "" {
+		return nil
+	}
+	return []clause.OrderByColumn{{Column: clause.Column{Name: m.Order, Raw: true}}}
+}
+
+// parseOrder 使用白名单 columns 解析 m.Order ，
+// 格式是 "name,-createdAt" ，- 是 desc ，+ 或者没有是 asc ，也可以是 "name desc, createdAt" 。
+// 不在白名单中或者方向不合法返回 GORMOrderError
+func (m *GORMListPage) parseOrder(columns map[string]string) ([]clause.OrderByColumn, error) {
+	if m == nil || m.Order == "" {
+		return nil, nil
+	}
+	ss := strings.Split(m.Order, ",")
+	orders := make([]clause.OrderByColumn, 0, len(ss))
+	for _, s := range ss {
+		key, dir, err := parseGORMOrder(s)
+		if err != nil {
+			return nil, err
+		}
+		column, ok := columns[key]
+		if !ok {
+			return nil, &GORMOrderError{Order: strings.TrimSpace(s)}
+		}
+		// 白名单是代码写的，写错了不是请求的错误
+		if !isGORMIdentifier(column) {
+			return nil, fmt.Errorf("gorm order %s invalid column %q", key, column)
+		}
+		orders = append(orders, clause.OrderByColumn{Column: clause.Column{Name: column}, Desc: dir})
+	}
+	return orders, nil
+}
+
+// parseGORMOrder 解析一项排序，返回键和是否 desc
+func parseGORMOrder(s string) (string, bool, error) {
+	fs := strings.Fields(s)
+	if len(fs) < 1 || len(fs) > 2 {
+		return "", false, &GORMOrderError{Order: strings.TrimSpace(s)}
+	}
+	key, desc := fs[0], false
+	switch key[0] {
+	case '-':
+		key, desc = key[1:], true
+	case '+':
+		key = key[1:]
+	}
+	if len(fs) == 2 {
+		// 不能同时使用前缀和方向
+		if key != fs[0] {
+			return "", false, &GORMOrderError{Order: strings.TrimSpace(s)}
+		}
+		switch strings.ToLower(fs[1]) {
+		case "asc":
+		case "desc":
+			desc = true
+		default:
+			return "", false, &GORMOrderError{Order: strings.TrimSpace(s)}
+		}
+	}
+	if key == "" {
+		return "", false, &GORMOrderError{Order: strings.TrimSpace(s)}
+	}
+	return key, desc, nil
+}
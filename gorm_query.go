@@ -0,0 +1,315 @@
+package util
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"unicode"
+
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// gormQueryPlans 缓存 GORMInitQuery 的解析结果，gormQueryPlanKey -> *gormQueryPlan
+	gormQueryPlans sync.Map
+)
+
+// gormQueryPlanKey 是 gormQueryPlans 的键，
+// GORMInitQueryTag 可以修改，所以要带上解析时使用的 tag
+type gormQueryPlanKey struct {
+	t   reflect.Type
+	tag string
+}
+
+// gormQueryPlan 是结构的 gq 解析结果，每个类型和 tag 只解析一次
+type gormQueryPlan struct {
+	fields []*gormQueryField
+	// 第一个字段的解析错误，包括分组的，不管字段的值，每次使用都返回
+	err error
+}
+
+// gormQueryField 是一个字段的解析结果
+type gormQueryField struct {
+	// 字段的下标
+	index int
+	// 字段的名称
+	name string
+	// 结构分组，and 或者 or ，空字符串不是分组
+	group string
+	// 分组的解析结果
+	plan *gormQueryPlan
+	// 操作
+	op string
+	// 列
+	columns []string
+	// 列的 clause.Column ，作为条件的第一个参数，解析的时候装箱，不用每次分配
+	cols []any
+	// 语句，第一个 ? 是列，null 和 notnull 是值为 true 的
+	sql string
+	// null 和 notnull 值为 false 的语句
+	not string
+	// like 的值的格式
+	format string
+	// 解析的错误，值不为空的时候返回
+	err error
+}
+
+// getGORMQueryPlan 返回结构 t 的解析结果
+func getGORMQueryPlan(t reflect.Type) *gormQueryPlan {
+	tag := GORMInitQueryTag
+	if p, ok := gormQueryPlans.Load(gormQueryPlanKey{t: t, tag: tag}); ok {
+		return p.(*gormQueryPlan)
+	}
+	// 解析完整后再保存，嵌套的结构可能引用自己
+	seen := make(map[reflect.Type]*gormQueryPlan)
+	p := newGORMQueryPlan(t, tag, seen)
+	for t, p := range seen {
+		gormQueryPlans.LoadOrStore(gormQueryPlanKey{t: t, tag: tag}, p)
+	}
+	return p
+}
+
+// newGORMQueryPlan 使用 tag 解析结构 t ，seen 是这一次解析过的结构
+func newGORMQueryPlan(t reflect.Type, tag string, seen map[reflect.Type]*gormQueryPlan) *gormQueryPlan {
+	if p, ok := seen[t]; ok {
+		return p
+	}
+	p := new(gormQueryPlan)
+	seen[t] = p
+	for i := 0; i < t.NumField(); i++ {
+		ft := t.Field(i)
+		tn := ft.Tag.Get(tag)
+		if tn == "-" {
+			continue
+		}
+		typ := ft.Type
+		if typ.Kind() == reflect.Pointer {
+			typ = typ.Elem()
+		}
+		f := &gormQueryField{index: i, name: ft.Name}
+		// 结构，分组
+		if typ.Kind() == reflect.Struct && (tn == "" || tn == "and" || tn == "or") {
+			f.group = "and"
+			if tn == "or" {
+				f.group = "or"
+			}
+			f.plan = newGORMQueryPlan(typ, tag, seen)
+			p.fields = append(p.fields, f)
+			continue
+		}
+		if tn == "" {
+			continue
+		}
+		op, column, _ := strings.Cut(tn, "=")
+		if column == "" {
+			column = ft.Name
+		}
+		f.op = op
+		f.columns = strings.Split(column, "|")
+		f.err = f.init(typ)
+		p.fields = append(p.fields, f)
+	}
+	// 错误
+	for _, f := range p.fields {
+		if f.err != nil {
+			p.err = f.err
+			break
+		}
+		if f.plan != nil && f.plan.err != nil {
+			p.err = f.plan.err
+			break
+		}
+	}
+	return p
+}
+
+// init 根据操作生成每个列的语句，typ 是字段的类型，不是指针
+func (f *gormQueryField) init(typ reflect.Type) error {
+	// 列名不能拼接到语句，防止注入
+	f.cols = make([]any, len(f.columns))
+	for i, c := range f.columns {
+		if !isGORMIdentifier(c) {
+			return fmt.Errorf("gq field %s invalid column %q", f.name, c)
+		}
+		f.cols[i] = clause.Column{Name: c}
+	}
+	switch f.op {
+	case "eq":
+		f.sql = "? = ?"
+	case "neq":
+		f.sql = "? != ?"
+	case "like":
+		f.sql, f.format = "? LIKE ?", "%%%v%%"
+	case "ilike":
+		f.sql, f.format = "LOWER(?) LIKE LOWER(?)", "%%%v%%"
+	case "prefix":
+		f.sql, f.format = "? LIKE ?", "%v%%"
+	case "suffix":
+		f.sql, f.format = "? LIKE ?", "%%%v"
+	case "gt":
+		f.sql = "? < ?"
+	case "gte":
+		f.sql = "? <= ?"
+	case "lt":
+		f.sql = "? > ?"
+	case "lte":
+		f.sql = "? >= ?"
+	case "in", "nin":
+		if typ.Kind() != reflect.Slice && typ.Kind() != reflect.Array {
+			return fmt.Errorf("gq field %s must be slice", f.name)
+		}
+		f.sql = "? IN ?"
+		if f.op == "nin" {
+			f.sql = "? NOT IN ?"
+		}
+	case "between":
+		if (typ.Kind() != reflect.Slice && typ.Kind() != reflect.Array) ||
+			(typ.Kind() == reflect.Array && typ.Len() != 2) {
+			return fmt.Errorf("gq field %s must be slice of 2", f.name)
+		}
+		f.sql = "? BETWEEN ? AND ?"
+	case "null", "notnull":
+		if typ.Kind() != reflect.Bool {
+			return fmt.Errorf("gq field %s must be bool", f.name)
+		}
+		f.sql, f.not = "? IS NULL", "? IS NOT NULL"
+		if f.op == "notnull" {
+			f.sql, f.not = f.not, f.sql
+		}
+	case "&":
+		f.sql = "(? & ?) = ?"
+	default:
+		return fmt.Errorf("gq field %s unknown tag %s", f.name, f.op)
+	}
+	return nil
+}
+
+// isGORMIdentifier 判断 s 是否合法的列名，字母，数字和下划线，不能以数字开头，
+// 可以有一个 . 分隔表名和列名，比如 User.Name
+func isGORMIdentifier(s string) bool {
+	ss := strings.Split(s, ".")
+	if len(ss) > 2 {
+		return false
+	}
+	for _, s := range ss {
+		if s == "" {
+			return false
+		}
+		for i, r := range s {
+			if r == '_' || unicode.IsLetter(r) {
+				continue
+			}
+			if i > 0 && unicode.IsDigit(r) {
+				continue
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// exprs 返回结构 v 的每一个字段的条件
+func (p *gormQueryPlan) exprs(v reflect.Value) ([]clause.Expression, error) {
+	// tag 写错了，即使值是空的也返回
+	if p.err != nil {
+		return nil, p.err
+	}
+	exprs := make([]clause.Expression, 0, len(p.fields))
+	for _, f := range p.fields {
+		fv := v.Field(f.index)
+		if fv.Kind() == reflect.Pointer {
+			// 空指针
+			if fv.IsNil() {
+				continue
+			}
+			fv = fv.Elem()
+		}
+		// 分组
+		if f.plan != nil {
+			es, err := f.plan.exprs(fv)
+			if err != nil {
+				return nil, err
+			}
+			if len(es) < 1 {
+				continue
+			}
+			if f.group == "or" {
+				exprs = append(exprs, gormQueryOr(es))
+			} else {
+				exprs = append(exprs, clause.And(es...))
+			}
+			continue
+		}
+		fvk := fv.Kind()
+		if fvk == reflect.String || fvk == reflect.Slice {
+			// 空字符串，空切片
+			if fv.Len() < 1 {
+				continue
+			}
+		}
+		e, err := f.expr(fv)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, e)
+	}
+	return exprs, nil
+}
+
+// expr 返回值 fv 的条件，多个列是 OR 。
+// 列使用 clause.Column 作为第一个参数，由 gorm 按照数据库的方言加引号
+func (f *gormQueryField) expr(fv reflect.Value) (clause.Expression, error) {
+	sql := f.sql
+	// 除了列的参数
+	var v0, v1 any
+	n := 1
+	switch f.op {
+	case "like", "ilike", "prefix", "suffix":
+		v0 = fmt.Sprintf(f.format, fv.Interface())
+	case "between":
+		if fv.Len() != 2 {
+			return nil, fmt.Errorf("gq field %s must be slice of 2", f.name)
+		}
+		v0, v1, n = fv.Index(0).Interface(), fv.Index(1).Interface(), 2
+	case "null", "notnull":
+		if !fv.Bool() {
+			sql = f.not
+		}
+		n = 0
+	case "&":
+		v0 = fv.Interface()
+		v1, n = v0, 2
+	default:
+		v0 = fv.Interface()
+	}
+	// 每个列一个条件
+	expr := func(col any) clause.Expression {
+		vars := make([]any, 1, 1+n)
+		vars[0] = col
+		if n > 0 {
+			vars = append(vars, v0)
+		}
+		if n > 1 {
+			vars = append(vars, v1)
+		}
+		return clause.Expr{SQL: sql, Vars: vars}
+	}
+	if len(f.cols) == 1 {
+		return expr(f.cols[0]), nil
+	}
+	es := make([]clause.Expression, len(f.cols))
+	for i, col := range f.cols {
+		es[i] = expr(col)
+	}
+	return clause.Or(es...), nil
+}
+
+// gormQueryOr 返回 OR 连接的条件，
+// 只有一个的 clause.OrConditions 会和前面的条件使用 OR 连接，所以直接返回
+func gormQueryOr(exprs []clause.Expression) clause.Expression {
+	if len(exprs) == 1 {
+		return exprs[0]
+	}
+	return clause.Or(exprs...)
+}
@@ -0,0 +1,52 @@
+package util
+
+import (
+	"database/sql"
+
+	"gorm.io/gorm"
+)
+
+// GORMTx 是 GORMTransaction 的事务，
+// 除了数据库操作，还记录事务提交后需要执行的函数，比如修改缓存
+type GORMTx struct {
+	// 事务
+	DB *gorm.DB
+	// 提交后执行
+	after []func()
+}
+
+// AfterCommit 添加事务提交后执行的函数，回滚则丢弃
+func (t *GORMTx) AfterCommit(fn func()) {
+	t.after = append(t.after, fn)
+}
+
+// Transaction 嵌套事务，使用 SavePoint 实现。
+// 嵌套事务回滚，它记录的函数也丢弃，否则等到最外层的事务提交后执行
+func (t *GORMTx) Transaction(fn func(tx *GORMTx) error, opts ...*sql.TxOptions) error {
+	n := new(GORMTx)
+	err := t.DB.Transaction(func(tx *gorm.DB) error {
+		n.DB = tx
+		return fn(n)
+	}, opts...)
+	if err == nil {
+		t.after = append(t.after, n.after...)
+	}
+	return err
+}
+
+// GORMTransaction 开启事务，fn 返回 nil 并且提交成功后，
+// 按照添加的顺序执行 AfterCommit 的函数，fn 返回错误或者提交失败则全部丢弃
+func GORMTransaction(db *gorm.DB, fn func(tx *GORMTx) error, opts ...*sql.TxOptions) error {
+	t := new(GORMTx)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		t.DB = tx
+		return fn(t)
+	}, opts...)
+	if err != nil {
+		return err
+	}
+	for _, f := range t.after {
+		f()
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrGORMConflict 表示乐观锁冲突，数据已经被修改或者删除，
+	// 可以使用 errors.Is 判断 GORMConflictError
+	ErrGORMConflict = errors.New("gorm version conflict")
+)
+
+// GORMConflictError 是乐观锁冲突的错误，数据库中的版本不是 Version ，
+// 或者数据已经删除了
+type GORMConflictError struct {
+	// 表名
+	Table string
+	// 更新的数据
+	Model any
+	// 更新时的版本
+	Version any
+}
+
+// Error 实现 error
+func (e *GORMConflictError) Error() string {
+	return fmt.Sprintf("gorm %s version %v conflict", e.Table, e.Version)
+}
+
+// Is 用于 errors.Is(err, ErrGORMConflict)
+func (e *GORMConflictError) Is(target error) bool {
+	return target == ErrGORMConflict
+}
+
+// gormVersionUpdate 使用乐观锁更新 m ，db 已经设置好 Model 和条件。
+// field 是版本字段的名称，整数类型的在更新时加 1 ，
+// 自动更新时间的比如 UpdatedAt 由 gorm 设置，注意时间精度内的并发修改不能发现。
+// save 为 true 更新所有字段，主键是零值的使用 Create 。
+// 更新的行数是 0 的时候，再查询版本没有修改的数据是否存在，不存在返回 GORMConflictError 。
+// 因为 MySQL 没有设置 clientFoundRows 的时候，没有修改任何值的更新返回 0 ，
+// 比如使用 UpdatedAt 同一秒内保存相同的数据。失败的时候 m 的版本会还原，
+// 返回的函数用于事务回滚后还原 m 的版本
+func gormVersionUpdate(db *gorm.DB, m any, field string, save bool) (*gorm.DB, func()) {
+	undo := func() {}
+	// 版本字段
+	stmt := &gorm.Statement{DB: db}
+	err := stmt.Parse(m)
+	if err != nil {
+		db.AddError(err)
+		return db, undo
+	}
+	f := stmt.Schema.LookUpField(field)
+	if f == nil {
+		db.AddError(fmt.Errorf("model %s has no version field %s", stmt.Schema.Name, field))
+		return db, undo
+	}
+	ctx := db.Statement.Context
+	rv := reflect.Indirect(reflect.ValueOf(m))
+	// 新的数据
+	if save {
+		create := true
+		for _, pk := range stmt.Schema.PrimaryFields {
+			if _, zero := pk.ValueOf(ctx, rv); !zero {
+				create = false
+				break
+			}
+		}
+		if create {
+			return db.Create(m), undo
+		}
+	}
+	// 新的版本
+	old, _ := f.ValueOf(ctx, rv)
+	undo = func() {
+		f.Set(ctx, rv, old)
+	}
+	if f.AutoUpdateTime == 0 {
+		v := reflect.New(f.FieldType).Elem()
+		switch f.FieldType.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			v.SetInt(reflect.ValueOf(old).Int() + 1)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			v.SetUint(reflect.ValueOf(old).Uint() + 1)
+		default:
+			db.AddError(fmt.Errorf("model %s version field %s must be integer or autoUpdateTime", stmt.Schema.Name, field))
+			return db, func() {}
+		}
+		f.Set(ctx, rv, v.Interface())
+	}
+	// 更新
+	db = db.Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: f.DBName}, Value: old})
+	// 用于更新的行数是 0 的时候检查
+	check := db.Session(&gorm.Session{})
+	if save {
+		db = db.Select("*").Updates(m)
+	} else {
+		db = db.Updates(m)
+	}
+	if db.Error == nil && db.RowsAffected < 1 {
+		var n int64
+		err = check.Count(&n).Error
+		if err != nil {
+			db.AddError(err)
+		} else if n < 1 {
+			db.AddError(&GORMConflictError{Table: stmt.Schema.Table, Model: m, Version: old})
+		}
+	}
+	if db.Error != nil {
+		undo()
+	}
+	//
+	return db, undo
+}
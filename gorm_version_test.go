@@ -0,0 +1,316 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type testGORMVersionModel struct {
+	ID      int64  `gorm:"primaryKey"`
+	Name    string `gorm:""`
+	Version int64  `gorm:""`
+	GORMTimeModel
+}
+
+func newTestGORMVersionCache(t *testing.T) *GORMCache[int64, *testGORMVersionModel] {
+	db := newTestGORMDB(t)
+	err := db.AutoMigrate(new(testGORMVersionModel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewGORMCacheAuto[int64, *testGORMVersionModel](db, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Version = "Version"
+	for i := int64(1); i <= 3; i++ {
+		_, err = c.Add(&testGORMVersionModel{ID: i, Name: "name"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c
+}
+
+func Test_GORMDBVersion(t *testing.T) {
+	c := newTestGORMVersionCache(t)
+	g := NewGORMDB[int64](c.DB, new(testGORMVersionModel))
+	g.Version = "Version"
+	// 更新
+	m := &testGORMVersionModel{ID: 1, Name: "new1"}
+	n, err := g.Update(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || m.Version != 1 {
+		t.FailNow()
+	}
+	// 冲突
+	m = &testGORMVersionModel{ID: 1, Name: "new2"}
+	_, err = g.Update(m)
+	if !errors.Is(err, ErrGORMConflict) || m.Version != 0 {
+		t.FailNow()
+	}
+	var ce *GORMConflictError
+	if !errors.As(err, &ce) || ce.Model != m || ce.Version != int64(0) {
+		t.FailNow()
+	}
+	// 保存
+	m.Version = 1
+	_, err = g.Save(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m = &testGORMVersionModel{ID: 1}
+	ok, err := g.Get(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || m.Name != "new2" || m.Version != 2 {
+		t.FailNow()
+	}
+	// 不存在也是冲突
+	_, err = g.Save(&testGORMVersionModel{ID: 10})
+	if !errors.Is(err, ErrGORMConflict) {
+		t.FailNow()
+	}
+	// 没有主键的添加
+	m = &testGORMVersionModel{Name: "new"}
+	_, err = g.Save(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.ID == 0 {
+		t.FailNow()
+	}
+	// 使用 UpdatedAt
+	g.Version = "UpdatedAt"
+	m = &testGORMVersionModel{ID: 2, Name: "new2"}
+	_, err = g.Update(m)
+	if !errors.Is(err, ErrGORMConflict) || m.UpdatedAt != 0 {
+		t.FailNow()
+	}
+	ok, err = g.Get(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Name = "new2"
+	_, err = g.Update(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 没有的字段
+	g.Version = "Version1"
+	_, err = g.Update(m)
+	if err == nil || errors.Is(err, ErrGORMConflict) {
+		t.FailNow()
+	}
+}
+
+func Test_GORMDBVersionNoChange(t *testing.T) {
+	c := newTestGORMVersionCache(t)
+	g := NewGORMDB[int64](c.DB, new(testGORMVersionModel))
+	g.Version = "UpdatedAt"
+	// 和 MySQL 一样，没有修改任何值的更新返回 0
+	c.DB.Callback().Update().After("gorm:update").Register("test:affected", func(db *gorm.DB) {
+		db.RowsAffected = 0
+	})
+	m := &testGORMVersionModel{ID: 1}
+	_, err := g.Get(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = g.Update(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 真的冲突
+	m = &testGORMVersionModel{ID: 2, Name: "new2"}
+	_, err = g.Update(m)
+	if !errors.Is(err, ErrGORMConflict) || m.UpdatedAt != 0 {
+		t.Fatal(err)
+	}
+	// 其他的数据有这个版本
+	m1 := &testGORMVersionModel{ID: 1}
+	_, err = g.Get(m1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m = &testGORMVersionModel{ID: 10, Name: "new10"}
+	m.UpdatedAt = m1.UpdatedAt
+	_, err = g.Update(m)
+	if !errors.Is(err, ErrGORMConflict) {
+		t.Fatal(err)
+	}
+}
+
+func Test_GORMCacheVersion(t *testing.T) {
+	c := newTestGORMVersionCache(t)
+	m1, err := c.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 其他的修改了
+	err = c.DB.Model(new(testGORMVersionModel)).Where("ID = ?", 1).Update("Version", 1).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 冲突，重新加载
+	m := *m1
+	m.Name = "new1"
+	_, err = c.Update(&m)
+	if !errors.Is(err, ErrGORMConflict) {
+		t.FailNow()
+	}
+	m1, _ = c.Get(1)
+	if m1.Version != 1 || m1.Name != "name" {
+		t.FailNow()
+	}
+	m = *m1
+	m.Name = "new1"
+	_, err = c.Save(&m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m1, _ = c.Get(1)
+	if m1.Version != 2 || m1.Name != "new1" {
+		t.FailNow()
+	}
+	// 批量，有一个冲突全部回滚
+	ms := []*testGORMVersionModel{
+		{ID: 2, Name: "new2"},
+		{ID: 3, Name: "new3", Version: 1},
+	}
+	_, err = c.BatchUpdate(ms)
+	if !errors.Is(err, ErrGORMConflict) || ms[0].Version != 0 || ms[1].Version != 1 {
+		t.FailNow()
+	}
+	m2, _ := c.Get(2)
+	if m2.Name != "name" {
+		t.FailNow()
+	}
+	ms[1].Version = 0
+	_, err = c.BatchSave(ms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m2, _ = c.Get(2)
+	m3, _ := c.Get(3)
+	if m2.Name != "new2" || m2.Version != 1 || m3.Name != "new3" || m3.Version != 1 {
+		t.FailNow()
+	}
+}
+
+func Test_GORMCacheFlushVersion(t *testing.T) {
+	c := newTestGORMVersionCache(t)
+	c.AddIndex("Version", true, func(m *testGORMVersionModel) any { return fmt.Sprintf("%d-%d", m.ID, m.Version) })
+	err := c.UpdateBehind(1, func(m *testGORMVersionModel) { m.Name = "behind" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, _ := c.Get(1)
+	s := c.Subscribe(10)
+	defer s.Close()
+	// 并发读取
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-quit:
+				return
+			default:
+			}
+			m, _ := c.Get(1)
+			_ = m.Version + m.UpdatedAt
+		}
+	}()
+	err = c.Flush()
+	close(quit)
+	<-done
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 内存中原来的数据没有被修改，写入后的数据替换了它
+	if old.Version != 0 || old.Name != "behind" {
+		t.Fatal(old)
+	}
+	m, _ := c.Get(1)
+	if m == old || m.Version != 1 || m.Name != "behind" {
+		t.Fatal(m)
+	}
+	// 索引和订阅
+	_, err = c.GetByIndex("Version", "1-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := <-s.C
+	if e.Op != GORMCacheOpUpdate || e.Old != old || e.New != m {
+		t.Fatal(e)
+	}
+	// 再次修改，版本正确
+	err = c.UpdateBehind(1, func(m *testGORMVersionModel) { m.Name = "behind2" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, _ = c.Get(1)
+	if m.Version != 2 || m.Name != "behind2" {
+		t.Fatal(m)
+	}
+}
+
+func Test_GORMCacheFlushConflict(t *testing.T) {
+	c := newTestGORMVersionCache(t)
+	conflict := func(k int64) {
+		// 其他的修改了
+		err := c.DB.Model(new(testGORMVersionModel)).Where("ID = ?", k).
+			Updates(map[string]any{"Name": "other", "Version": 5}).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = c.UpdateBehind(k, func(m *testGORMVersionModel) { m.Name = "behind" })
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = c.Flush()
+		if !errors.Is(err, ErrGORMConflict) {
+			t.Fatal(err)
+		}
+		// 不重试，使用数据库的数据
+		m, _ := c.Get(k)
+		if m.Name != "other" || m.Version != 5 {
+			t.Fatal(m)
+		}
+		err = c.Flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, _ = c.Get(k)
+		if m.Name != "other" || m.Version != 5 {
+			t.Fatal(m)
+		}
+	}
+	// onError 为 nil
+	conflict(1)
+	// onError 返回 true 也不重试
+	var failed []int64
+	c.WriteBehind(context.Background(), time.Hour, 0, false, func(ks []int64, err error) bool {
+		failed = ks
+		return true
+	})
+	defer c.Close()
+	conflict(2)
+	if len(failed) != 1 || failed[0] != 2 {
+		t.Fatal(failed)
+	}
+}
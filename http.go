@@ -9,6 +9,8 @@ import (
 	"net/http"
 	"net/url"
 	"reflect"
+	"strconv"
+	"sync"
 	"time"
 )
 
@@ -316,9 +318,6 @@ var (
 // HTTPQuery 将结构体 v 格式化到 url.Values
 // 只扫描一层，并略过空值
 func HTTPQuery(v any, q url.Values) url.Values {
-	if q == nil {
-		q = make(url.Values)
-	}
 	rv := reflect.ValueOf(v)
 	vk := rv.Kind()
 	if vk == reflect.Pointer {
@@ -332,12 +331,12 @@ func HTTPQuery(v any, q url.Values) url.Values {
 }
 
 func httpQuery(v reflect.Value, q url.Values) url.Values {
-	vt := v.Type()
-	for i := 0; i < vt.NumField(); i++ {
-		fv := v.Field(i)
-		if !fv.IsValid() {
-			continue
-		}
+	fs := getHTTPQueryPlan(v.Type())
+	if q == nil {
+		q = make(url.Values, len(fs))
+	}
+	for _, f := range fs {
+		fv := v.Field(f.index)
 		fvk := fv.Kind()
 		if fvk == reflect.Pointer {
 			// 空指针
@@ -347,22 +346,112 @@ func httpQuery(v reflect.Value, q url.Values) url.Values {
 			fv = fv.Elem()
 			fvk = fv.Kind()
 		}
-		// 结构，只一层
-		if fvk == reflect.Struct {
-			continue
-		}
 		if fvk == reflect.String {
 			// 空字符串
-			if fv.IsZero() {
+			if fv.Len() < 1 {
 				continue
 			}
 		}
-		ft := vt.Field(i)
-		tn := ft.Tag.Get(HTTPQueryTag)
+		q.Set(f.name, f.format(fv))
+	}
+	return q
+}
+
+var (
+	// httpQueryPlans 缓存 HTTPQuery 的解析结果，httpQueryPlanKey -> []*httpQueryField
+	httpQueryPlans sync.Map
+)
+
+// httpQueryPlanKey 是 httpQueryPlans 的键，
+// HTTPQueryTag 可以修改，所以要带上解析时使用的 tag
+type httpQueryPlanKey struct {
+	t   reflect.Type
+	tag string
+}
+
+// httpQueryField 是 HTTPQuery 一个字段的解析结果
+type httpQueryField struct {
+	// 字段的下标
+	index int
+	// tag 的名称
+	name string
+	// 格式化，和 fmt.Sprintf("%v") 一样
+	format func(reflect.Value) string
+}
+
+// getHTTPQueryPlan 返回结构 t 的解析结果，每个类型和 tag 只解析一次
+func getHTTPQueryPlan(t reflect.Type) []*httpQueryField {
+	k := httpQueryPlanKey{t: t, tag: HTTPQueryTag}
+	if p, ok := httpQueryPlans.Load(k); ok {
+		return p.([]*httpQueryField)
+	}
+	p, _ := httpQueryPlans.LoadOrStore(k, newHTTPQueryPlan(t, k.tag))
+	return p.([]*httpQueryField)
+}
+
+// newHTTPQueryPlan 使用 tag 解析结构 t
+func newHTTPQueryPlan(t reflect.Type, tag string) []*httpQueryField {
+	var fs []*httpQueryField
+	for i := 0; i < t.NumField(); i++ {
+		ft := t.Field(i)
+		typ := ft.Type
+		if typ.Kind() == reflect.Pointer {
+			typ = typ.Elem()
+		}
+		// 结构，只一层
+		if typ.Kind() == reflect.Struct {
+			continue
+		}
+		tn := ft.Tag.Get(tag)
 		if tn == "" || tn == "-" {
 			continue
 		}
-		q.Set(tn, fmt.Sprintf("%v", fv.Interface()))
+		fs = append(fs, &httpQueryField{index: i, name: tn, format: httpQueryFormat(typ)})
 	}
-	return q
+	return fs
+}
+
+var (
+	httpQueryStringer = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+	httpQueryError    = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// httpQueryFormat 返回类型 t 的格式化函数，
+// 实现了 fmt.Stringer 或者 error 的使用 fmt ，基本类型使用 strconv 减少内存分配
+func httpQueryFormat(t reflect.Type) func(reflect.Value) string {
+	if t.Implements(httpQueryStringer) || t.Implements(httpQueryError) {
+		return httpQuerySprint
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return func(v reflect.Value) string {
+			return v.String()
+		}
+	case reflect.Bool:
+		return func(v reflect.Value) string {
+			return strconv.FormatBool(v.Bool())
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return func(v reflect.Value) string {
+			return strconv.FormatInt(v.Int(), 10)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return func(v reflect.Value) string {
+			return strconv.FormatUint(v.Uint(), 10)
+		}
+	case reflect.Float32:
+		return func(v reflect.Value) string {
+			return strconv.FormatFloat(v.Float(), 'g', -1, 32)
+		}
+	case reflect.Float64:
+		return func(v reflect.Value) string {
+			return strconv.FormatFloat(v.Float(), 'g', -1, 64)
+		}
+	}
+	return httpQuerySprint
+}
+
+// httpQuerySprint 使用 fmt 格式化
+func httpQuerySprint(v reflect.Value) string {
+	return fmt.Sprintf("%v", v.Interface())
 }
@@ -1,6 +1,12 @@
 package util
 
-import "testing"
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
 
 func Test_HTTPQuery(t *testing.T) {
 	s := struct {
@@ -16,3 +22,98 @@ func Test_HTTPQuery(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func Test_HTTPQueryTag(t *testing.T) {
+	s := struct {
+		A int `query:"a" form:"b"`
+	}{A: 1}
+	if q := HTTPQuery(&s, nil); q.Get("a") != "1" || q.Has("b") {
+		t.FailNow()
+	}
+	// 使用过后修改 tag
+	defer func(tag string) { HTTPQueryTag = tag }(HTTPQueryTag)
+	HTTPQueryTag = "form"
+	if q := HTTPQuery(&s, nil); q.Get("b") != "1" || q.Has("a") {
+		t.FailNow()
+	}
+}
+
+type testHTTPQuery struct {
+	A int64     `query:"a"`
+	B string    `query:"b"`
+	C *int64    `query:"c"`
+	D *string   `query:"d"`
+	E bool      `query:"e"`
+	F float64   `query:"f"`
+	G uint32    `query:"g"`
+	H string    `query:"h"`
+	I *int      `query:"i"`
+	J string    `query:"j"`
+	K int8      `query:"k"`
+	L *bool     `query:"l"`
+	M string    `query:"-"`
+	N time.Time `query:"n"`
+	O float32   `query:"o"`
+}
+
+func newTestHTTPQuery() *testHTTPQuery {
+	return &testHTTPQuery{
+		A: 1, B: "b", C: testGORMPtr[int64](3), E: true, F: 1.5, G: 7,
+		H: "h", J: "j", K: -8, L: testGORMPtr(false), M: "m", O: 0.25,
+	}
+}
+
+func Test_HTTPQueryFormat(t *testing.T) {
+	q := HTTPQuery(newTestHTTPQuery(), nil)
+	for k, v := range map[string]string{
+		"a": "1", "b": "b", "c": "3", "e": "true", "f": "1.5", "g": "7",
+		"h": "h", "j": "j", "k": "-8", "l": "false", "o": "0.25",
+	} {
+		if q.Get(k) != v {
+			t.Fatal(k, q.Get(k))
+		}
+	}
+	if q.Has("d") || q.Has("i") || q.Has("m") || q.Has("n") || q.Has("-") {
+		t.FailNow()
+	}
+}
+
+func Benchmark_HTTPQuery(b *testing.B) {
+	v := newTestHTTPQuery()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		HTTPQuery(v, nil)
+	}
+}
+
+// Benchmark_HTTPQueryNoCache 每次遍历字段和解析 tag ，用于比较
+func Benchmark_HTTPQueryNoCache(b *testing.B) {
+	v := reflect.ValueOf(newTestHTTPQuery()).Elem()
+	vt := v.Type()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		q := make(url.Values)
+		for i := 0; i < vt.NumField(); i++ {
+			fv := v.Field(i)
+			if fv.Kind() == reflect.Pointer {
+				if fv.IsNil() {
+					continue
+				}
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct {
+				continue
+			}
+			if fv.Kind() == reflect.String && fv.IsZero() {
+				continue
+			}
+			tn := vt.Field(i).Tag.Get(HTTPQueryTag)
+			if tn == "" || tn == "-" {
+				continue
+			}
+			q.Set(tn, fmt.Sprintf("%v", fv.Interface()))
+		}
+	}
+}
@@ -180,6 +180,83 @@ func copyStructAll(dst, src reflect.Value) {
 	}
 }
 
+// DeepCopyStruct 拷贝 src 到 dst ，然后把 dst 中的指针，切片，map 和接口字段
+// 替换成新的拷贝，使 dst 和 src 不共享数据。不能有循环引用。
+// 类型相同的时候整个结构赋值，不能访问的字段是浅拷贝，引用的数据还是共享的；
+// 类型不同的时候使用 CopyStructAll ，不能访问的字段不拷贝，是零值
+func DeepCopyStruct(dst, src any) {
+	dstVal := reflect.ValueOf(dst)
+	srcVal := reflect.ValueOf(src)
+	if dstVal.Kind() == reflect.Pointer && dstVal.Type() == srcVal.Type() &&
+		dstVal.Elem().Kind() == reflect.Struct {
+		dstVal.Elem().Set(srcVal.Elem())
+	} else {
+		CopyStructAll(dst, src)
+	}
+	deepCopyFields(dstVal.Elem())
+}
+
+// deepCopyFields 把 v 中可以设置的字段替换成深拷贝
+func deepCopyFields(v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		if f.CanSet() {
+			f.Set(deepCopyValue(f))
+		}
+	}
+}
+
+// deepCopyValue 返回 v 的深拷贝
+func deepCopyValue(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return v
+		}
+		n := reflect.New(v.Type().Elem())
+		n.Elem().Set(deepCopyValue(v.Elem()))
+		return n
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		n := reflect.New(v.Type()).Elem()
+		n.Set(deepCopyValue(v.Elem()))
+		return n
+	case reflect.Struct:
+		n := reflect.New(v.Type()).Elem()
+		n.Set(v)
+		deepCopyFields(n)
+		return n
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		n := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			n.Index(i).Set(deepCopyValue(v.Index(i)))
+		}
+		return n
+	case reflect.Array:
+		n := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			n.Index(i).Set(deepCopyValue(v.Index(i)))
+		}
+		return n
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		n := reflect.MakeMapWithSize(v.Type(), v.Len())
+		it := v.MapRange()
+		for it.Next() {
+			n.SetMapIndex(it.Key(), deepCopyValue(it.Value()))
+		}
+		return n
+	}
+	return v
+}
+
 // StructToMap 将 v 转换为 map，v 必须是结构体
 func StructToMap(v any) map[string]any {
 	return structToMap(reflect.ValueOf(v))
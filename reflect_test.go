@@ -141,6 +141,65 @@ func Test_CopyStructAll(t *testing.T) {
 	}
 }
 
+type DeepCopyStruct1 struct {
+	A *CopyStruct1
+	B []*string
+	C map[string]*CopyStruct1
+	D any
+	E [2]*string
+	CopyStruct2
+}
+
+func Test_DeepCopyStruct(t *testing.T) {
+	s := "s"
+	src := new(DeepCopyStruct1)
+	src.A = &CopyStruct1{A: "a"}
+	src.B = []*string{&s}
+	src.C = map[string]*CopyStruct1{"c": {A: "c"}}
+	src.D = &CopyStruct1{A: "d"}
+	src.E[0] = &s
+	src.CopyStruct2.B = &s
+	//
+	dst := new(DeepCopyStruct1)
+	DeepCopyStruct(dst, src)
+	if dst.A == src.A || dst.A.A != "a" ||
+		dst.B[0] == src.B[0] || *dst.B[0] != s ||
+		dst.C["c"] == src.C["c"] || dst.C["c"].A != "c" ||
+		dst.D == src.D || dst.D.(*CopyStruct1).A != "d" ||
+		dst.E[0] == src.E[0] || *dst.E[0] != s ||
+		dst.CopyStruct2.B == src.CopyStruct2.B || *dst.CopyStruct2.B != s {
+		t.FailNow()
+	}
+}
+
+type DeepCopyStruct2 struct {
+	A *string
+	b int
+	c *string
+}
+
+type DeepCopyStruct3 struct {
+	A *string
+	b int
+}
+
+func Test_DeepCopyStructUnexported(t *testing.T) {
+	s := "s"
+	src := &DeepCopyStruct2{A: &s, b: 1, c: &s}
+	// 类型相同，不能访问的字段浅拷贝
+	dst := new(DeepCopyStruct2)
+	DeepCopyStruct(dst, src)
+	if dst.A == src.A || *dst.A != s || dst.b != 1 || dst.c != src.c {
+		t.FailNow()
+	}
+	// 类型不同，不能访问的字段是零值
+	dst3 := new(DeepCopyStruct3)
+	DeepCopyStruct(dst3, src)
+	if dst3.A == src.A || *dst3.A != s || dst3.b != 0 {
+		t.FailNow()
+	}
+}
+
 type StructToMap1 struct {
 	A int
 	B *string
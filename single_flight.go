@@ -0,0 +1,76 @@
+package util
+
+import (
+	"context"
+	"sync"
+)
+
+// SingleFlight 合并相同 key 的并发调用，
+// 同一时间相同的 key 只执行一次，其他的等待并共享结果
+type SingleFlight[K comparable, V any] struct {
+	l sync.Mutex
+	d map[K]*singleFlightCall[V]
+}
+
+// singleFlightCall 是正在执行的调用
+type singleFlightCall[V any] struct {
+	done chan struct{}
+	v    V
+	err  error
+}
+
+// Do 执行 fn 并返回结果，如果相同的 k 正在执行，等待并返回它的结果
+func (s *SingleFlight[K, V]) Do(k K, fn func() (V, error)) (V, error) {
+	c, ok := s.call(k)
+	if ok {
+		<-c.done
+		return c.v, c.err
+	}
+	s.run(k, c, fn)
+	//
+	return c.v, c.err
+}
+
+// DoContext 和 Do 一样，但是 fn 在新的协程中执行，
+// 每个调用者只等待到自己的 ctx 取消，返回 ctx.Err() ，fn 继续执行，结果给其他的调用者。
+// 所以 fn 不要使用调用者的 ctx
+func (s *SingleFlight[K, V]) DoContext(ctx context.Context, k K, fn func() (V, error)) (V, error) {
+	c, ok := s.call(k)
+	if !ok {
+		go s.run(k, c, fn)
+	}
+	select {
+	case <-c.done:
+		return c.v, c.err
+	case <-ctx.Done():
+		var v V
+		return v, ctx.Err()
+	}
+}
+
+// call 返回 k 正在执行的调用，没有的话创建一个，ok 表示正在执行
+func (s *SingleFlight[K, V]) call(k K) (*singleFlightCall[V], bool) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if s.d == nil {
+		s.d = make(map[K]*singleFlightCall[V])
+	}
+	// 正在执行
+	if c, ok := s.d[k]; ok {
+		return c, true
+	}
+	c := &singleFlightCall[V]{done: make(chan struct{})}
+	s.d[k] = c
+	return c, false
+}
+
+// run 执行 fn ，保存结果并通知等待的调用者
+func (s *SingleFlight[K, V]) run(k K, c *singleFlightCall[V], fn func() (V, error)) {
+	defer func() {
+		s.l.Lock()
+		delete(s.d, k)
+		s.l.Unlock()
+		close(c.done)
+	}()
+	c.v, c.err = fn()
+}
@@ -0,0 +1,59 @@
+package util
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_SingleFlight(t *testing.T) {
+	var s SingleFlight[int, int]
+	var n int32
+	var w sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		w.Add(1)
+		go func() {
+			defer w.Done()
+			v, err := s.Do(1, func() (int, error) {
+				atomic.AddInt32(&n, 1)
+				time.Sleep(time.Millisecond * 50)
+				return 1, nil
+			})
+			if err != nil || v != 1 {
+				t.Error("bad result")
+			}
+		}()
+	}
+	w.Wait()
+	if n != 1 {
+		t.FailNow()
+	}
+}
+
+func Test_SingleFlightContext(t *testing.T) {
+	var s SingleFlight[int, int]
+	var n int32
+	fn := func() (int, error) {
+		atomic.AddInt32(&n, 1)
+		time.Sleep(time.Millisecond * 50)
+		return 1, nil
+	}
+	// 第一个取消，不影响第二个
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, err := s.DoContext(ctx, 1, fn)
+		errs <- err
+	}()
+	time.Sleep(time.Millisecond * 10)
+	cancel()
+	if err := <-errs; err != context.Canceled {
+		t.Fatal(err)
+	}
+	v, err := s.DoContext(context.Background(), 1, fn)
+	if err != nil || v != 1 || atomic.LoadInt32(&n) != 1 {
+		t.FailNow()
+	}
+}